@@ -2,6 +2,7 @@ package client
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -31,10 +32,18 @@ type Client struct {
 	pending  map[uint64]*Call // 存储未处理完的请求，键是编号，值是 Call 实例
 	closing  bool             // user has called Close
 	shutdown bool             // server has told us to stop
+	retry    *RetryPolicy     // retry 为 nil 时每次调用只尝试一次
 }
 
 var _ io.Closer = (*Client)(nil)
 
+// Caller 是同步调用的接口，Client 和 xclient.XClient 都实现了它，geerpc-gen 生成的客户端基于它调用
+type Caller interface {
+	Call(ctx context.Context, serviceMethod string, args, reply interface{}) error
+}
+
+var _ Caller = (*Client)(nil)
+
 var ErrShutdown = errors.New("connection is shutdown")
 
 func (client *Client) Close() error {
@@ -7,7 +7,8 @@ type Call struct {
 	Args          interface{} // 函数的参数
 	Reply         interface{} // 从函数返回
 	Error         error
-	Done          chan *Call // Strobes when call is complete.
+	Metadata      map[string]string // 随请求 Header 发送的元数据
+	Done          chan *Call        // Strobes when call is complete.
 }
 
 // 为了支持异步调用，Call 结构体中添加了一个字段 Done, Done 的类型是 chan *Call，当调用结束时，会调用 call.done() 通知调用方
@@ -0,0 +1,10 @@
+package client
+
+import "context"
+
+// Invoke 泛型版本的 Call，reply 的类型由 Resp 决定，c 可以是 Client 或 XClient
+func Invoke[Req, Resp any](ctx context.Context, c Caller, serviceMethod string, req Req) (Resp, error) {
+	var resp Resp
+	err := c.Call(ctx, serviceMethod, req, &resp)
+	return resp, err
+}
@@ -0,0 +1,18 @@
+package client
+
+import "context"
+
+type metadataKey struct{}
+
+// ContextWithMetadata 将 md 附加到 ctx 上，Client.Call 会将其放在请求的 Header 中发送给服务端，
+// 例如链路追踪的 ID、调用方的名称等。服务端 RegisterFunc 注册的函数和第一个参数是 context.Context 的方法
+// 能通过 service.MetadataFromContext 读取。
+func ContextWithMetadata(ctx context.Context, md map[string]string) context.Context {
+	return context.WithValue(ctx, metadataKey{}, md)
+}
+
+// MetadataFromContext returns the metadata attached by ContextWithMetadata
+func MetadataFromContext(ctx context.Context) map[string]string {
+	md, _ := ctx.Value(metadataKey{}).(map[string]string)
+	return md
+}
@@ -2,10 +2,16 @@ package client
 
 import (
 	"errors"
-	"fmt"
 	"geeRPC/codec/codec"
 )
 
+// ServerError 服务端返回的错误，可以用 errors.Is 与 ServerError("...") 比较
+type ServerError string
+
+func (e ServerError) Error() string {
+	return string(e)
+}
+
 // 接收功能，接收到的响应有三种情况：
 // call 不存在，可能是请求没有发送完整，或者因为其他原因被取消，但是服务端仍旧处理了。
 // call 存在，但服务端处理出错，即 h.Error 不为空。
@@ -24,7 +30,7 @@ func (client *Client) receive() {
 			err = client.cc.ReadBody(nil)
 
 		case header.Error != "":
-			call.Error = fmt.Errorf(header.Error)
+			call.Error = ServerError(header.Error)
 			err = client.cc.ReadBody(nil)
 			call.done()
 		default:
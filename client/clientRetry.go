@@ -0,0 +1,184 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// DefaultRetryableErrors 默认可重试的错误，均为连接层面的错误，服务端返回的业务错误不会被重试。
+var DefaultRetryableErrors = []error{
+	ErrShutdown,
+	ErrConnectTimeout,
+	syscall.ECONNREFUSED,
+	syscall.ECONNRESET,
+	syscall.EPIPE,
+}
+
+// RetryPolicy 声明式的重试策略，可以挂载到 Client 或 XClient 上。
+// 重试只会发生在 ctx 的截止时间之内，等待退避时间会超过截止时间时直接返回最后一次的错误。
+type RetryPolicy struct {
+	MaxAttempts       int           // 包含第一次调用在内的最大尝试次数，<= 1 表示不重试
+	InitialBackoff    time.Duration // 第一次重试前的等待时间
+	MaxBackoff        time.Duration // 退避时间的上限，0 表示不限制
+	BackoffMultiplier float64       // 每次重试后退避时间的增长倍数，<= 1 表示固定退避
+	// RetryableErrors 可重试的错误，通过 errors.Is 匹配其中任意一项即认为可重试，为空时使用 DefaultRetryableErrors
+	RetryableErrors []error
+	// Methods 按 "Service.Method" 覆盖的重试策略
+	Methods map[string]*RetryPolicy
+	// Budget 重试预算，多个策略可以共享同一个预算，避免故障时的重试风暴，nil 表示不限制
+	Budget *RetryBudget
+}
+
+// DefaultRetryPolicy 最多尝试 3 次，退避时间从 100ms 开始翻倍，最长 1s
+var DefaultRetryPolicy = &RetryPolicy{
+	MaxAttempts:       3,
+	InitialBackoff:    time.Millisecond * 100,
+	MaxBackoff:        time.Second,
+	BackoffMultiplier: 2,
+}
+
+// ForMethod returns the policy that applies to serviceMethod.
+func (p *RetryPolicy) ForMethod(serviceMethod string) *RetryPolicy {
+	if mp, ok := p.Methods[serviceMethod]; ok && mp != nil {
+		if mp.Budget == nil {
+			// per-method overrides share the budget of the parent policy
+			override := *mp
+			override.Budget = p.Budget
+			return &override
+		}
+		return mp
+	}
+	return p
+}
+
+// Retryable reports whether err is one of the retryable errors.
+func (p *RetryPolicy) Retryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	targets := p.RetryableErrors
+	if len(targets) == 0 {
+		targets = DefaultRetryableErrors
+	}
+	for _, target := range targets {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// backoff 返回第 attempt 次重试（从 1 开始）前需要等待的时间，加入 [0.8, 1.2) 的随机抖动，避免多个客户端同时重试。
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	d := float64(p.InitialBackoff)
+	if p.BackoffMultiplier > 1 {
+		for i := 1; i < attempt; i++ {
+			d *= p.BackoffMultiplier
+			if p.MaxBackoff > 0 && d > float64(p.MaxBackoff) {
+				break
+			}
+		}
+	}
+	if p.MaxBackoff > 0 && d > float64(p.MaxBackoff) {
+		d = float64(p.MaxBackoff)
+	}
+	return time.Duration(d * (0.8 + 0.4*rand.Float64()))
+}
+
+// Do calls fn until it succeeds, the error is not retryable, the attempts or
+// the retry budget run out, or ctx is done. attempt starts with 0.
+// Only retryable errors consume the budget, errors returned by the methods never disable retries.
+func (p *RetryPolicy) Do(ctx context.Context, serviceMethod string, fn func(attempt int) error) error {
+	policy := p.ForMethod(serviceMethod)
+	for attempt := 0; ; attempt++ {
+		err := fn(attempt)
+		if err == nil {
+			policy.Budget.onSuccess()
+			return nil
+		}
+		if !policy.Retryable(err) {
+			return err
+		}
+		policy.Budget.onFailure()
+		if attempt+1 >= policy.MaxAttempts || ctx.Err() != nil || !policy.Budget.allow() {
+			return err
+		}
+		wait := policy.backoff(attempt + 1)
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(wait).After(deadline) {
+			return err
+		}
+		t := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return err
+		case <-t.C:
+		}
+	}
+}
+
+// RetryBudget 重试预算，与 gRPC 的 retry throttling 相同：
+// 每次失败消耗 1 个 token，每次成功返还 ratio 个 token，token 数不超过 max，
+// 只有当 token 数大于 max 的一半时才允许重试。
+type RetryBudget struct {
+	mu     sync.Mutex
+	tokens float64
+	max    float64
+	ratio  float64
+}
+
+// NewRetryBudget creates a RetryBudget that starts full.
+func NewRetryBudget(maxTokens, ratio float64) *RetryBudget {
+	return &RetryBudget{tokens: maxTokens, max: maxTokens, ratio: ratio}
+}
+
+func (b *RetryBudget) allow() bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.tokens > b.max/2
+}
+
+func (b *RetryBudget) onSuccess() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens += b.ratio
+	if b.tokens > b.max {
+		b.tokens = b.max
+	}
+}
+
+func (b *RetryBudget) onFailure() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens--
+	if b.tokens < 0 {
+		b.tokens = 0
+	}
+}
+
+// SetRetryPolicy attaches a retry policy to the client, nil disables retries.
+// 由于 Client 只有一条连接，连接断开后的重试会立即失败，重试只对列在 RetryableErrors 中的 ServerError 有意义。
+func (client *Client) SetRetryPolicy(p *RetryPolicy) {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	client.retry = p
+}
+
+func (client *Client) retryPolicy() *RetryPolicy {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	return client.retry
+}
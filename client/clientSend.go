@@ -17,6 +17,7 @@ func (client *Client) send(call *Call) {
 	client.header.ServiceMethod = call.ServiceMethod
 	client.header.Seq = seq
 	client.header.Error = ""
+	client.header.Metadata = call.Metadata
 
 	// encode and send the request
 	if err := client.cc.Write(&client.header, call.Args); err != nil {
@@ -9,6 +9,9 @@ import (
 	"time"
 )
 
+// ErrConnectTimeout is returned when connecting to the server takes longer than Option.ConnectTimeout
+var ErrConnectTimeout = errors.New("rpc client: connect timeout")
+
 type clientResult struct {
 	client *Client
 	err    error
@@ -43,7 +46,7 @@ func dialTimeout(f newClientFunc, network, address string, opts ...*service.Opti
 	}
 	select {
 	case <-time.After(opt.ConnectTimeout):
-		return nil, fmt.Errorf("rpc client: connect timeout: expect within %s", opt.ConnectTimeout)
+		return nil, fmt.Errorf("%w: expect within %s", ErrConnectTimeout, opt.ConnectTimeout)
 	case result := <-ch:
 		return result.client, result.err
 	}
@@ -57,8 +60,25 @@ func Dial(network, address string, opts ...*service.Option) (*Client, error) {
 // Call invokes the named function, waits for it to complete,
 // and returns its error status.
 // Client.Call 的超时处理机制，使用 context 包实现，控制权交给用户，控制更为灵活。
+// 如果设置了 RetryPolicy，失败的调用会按照策略重试。
 func (client *Client) Call(ctx context.Context, serviceMethod string, args, reply interface{}) error {
-	call := client.Go(serviceMethod, args, reply, make(chan *Call, 1))
+	if p := client.retryPolicy(); p != nil {
+		return p.Do(ctx, serviceMethod, func(int) error {
+			return client.call(ctx, serviceMethod, args, reply)
+		})
+	}
+	return client.call(ctx, serviceMethod, args, reply)
+}
+
+func (client *Client) call(ctx context.Context, serviceMethod string, args, reply interface{}) error {
+	call := &Call{
+		ServiceMethod: serviceMethod,
+		Args:          args,
+		Reply:         reply,
+		Metadata:      MetadataFromContext(ctx),
+		Done:          make(chan *Call, 1),
+	}
+	client.send(call)
 	select {
 	case <-ctx.Done():
 		client.removeCall(call.Seq)
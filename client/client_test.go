@@ -1,27 +1,32 @@
 package client
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"geeRPC/service"
 	"net"
 	"os"
 	"runtime"
 	"testing"
+	"time"
 )
 
 func TestXDial(t *testing.T) {
 	if runtime.GOOS == "linux" {
-		ch := make(chan struct{})
+		ch := make(chan error)
 		addr := "/tmp/geerpc.sock"
 		go func() {
 			_ = os.Remove(addr)
 			l, err := net.Listen("unix", addr)
-			if err != nil {
-				t.Fatal("failed to listen unix socket")
+			ch <- err
+			if err == nil {
+				service.Accept(l)
 			}
-			ch <- struct{}{}
-			service.Accept(l)
 		}()
-		<-ch
+		if err := <-ch; err != nil {
+			t.Fatal("failed to listen unix socket:", err)
+		}
 		_, err := XDial("unix@" + addr)
 		//_assert(err == nil, "failed to connect unix socket")
 		if err != nil {
@@ -29,3 +34,156 @@ func TestXDial(t *testing.T) {
 		}
 	}
 }
+
+func TestRetryPolicy_Do(t *testing.T) {
+	p := &RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond}
+	attempts := 0
+	err := p.Do(context.Background(), "Foo.Sum", func(int) error {
+		attempts++
+		if attempts < 3 {
+			return ErrShutdown
+		}
+		return nil
+	})
+	if err != nil || attempts != 3 {
+		t.Fatalf("expect success after 3 attempts, got %d attempts, err %v", attempts, err)
+	}
+
+	attempts = 0
+	err = p.Do(context.Background(), "Foo.Sum", func(int) error {
+		attempts++
+		return errors.New("rpc server: can't find service Foo")
+	})
+	if err == nil || attempts != 1 {
+		t.Fatalf("non-retryable error shouldn't be retried, got %d attempts", attempts)
+	}
+
+	attempts = 0
+	p.RetryableErrors = []error{ServerError("busy")}
+	_ = p.Do(context.Background(), "Foo.Sum", func(int) error {
+		attempts++
+		return ServerError("busy")
+	})
+	if attempts != 3 {
+		t.Fatalf("expect the server error in RetryableErrors to be retried, got %d attempts", attempts)
+	}
+}
+
+func TestRetryPolicy_Budget(t *testing.T) {
+	p := &RetryPolicy{MaxAttempts: 10, InitialBackoff: time.Millisecond, Budget: NewRetryBudget(4, 1)}
+	// errors of the method don't consume the budget
+	for i := 0; i < 10; i++ {
+		_ = p.Do(context.Background(), "Foo.Sum", func(int) error { return errors.New("invalid args") })
+	}
+	count := func() int {
+		attempts := 0
+		_ = p.Do(context.Background(), "Foo.Sum", func(int) error {
+			attempts++
+			return ErrShutdown
+		})
+		return attempts
+	}
+	// 4 tokens, retries are allowed while more than 2 are left
+	if n := count(); n != 2 {
+		t.Fatalf("expect the budget to allow 1 retry, got %d attempts", n)
+	}
+	if n := count(); n != 1 {
+		t.Fatalf("expect no retry with the budget exhausted, got %d attempts", n)
+	}
+	for i := 0; i < 4; i++ {
+		_ = p.Do(context.Background(), "Foo.Sum", func(int) error { return nil })
+	}
+	if n := count(); n != 2 {
+		t.Fatalf("expect successes to refill the budget, got %d attempts", n)
+	}
+}
+
+func TestRetryPolicy_ForMethod(t *testing.T) {
+	budget := NewRetryBudget(10, 1)
+	p := &RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: time.Millisecond,
+		Budget:         budget,
+		Methods:        map[string]*RetryPolicy{"Foo.Once": {MaxAttempts: 1}},
+	}
+	if mp := p.ForMethod("Foo.Once"); mp.MaxAttempts != 1 || mp.Budget != budget {
+		t.Fatalf("expect the override sharing the budget, got %+v", mp)
+	}
+	if p.ForMethod("Foo.Sum") != p {
+		t.Fatal("expect methods without an override to use the policy")
+	}
+	attempts := 0
+	_ = p.Do(context.Background(), "Foo.Once", func(int) error {
+		attempts++
+		return ErrShutdown
+	})
+	if attempts != 1 {
+		t.Fatalf("expect Foo.Once not to be retried, got %d attempts", attempts)
+	}
+}
+
+func TestRetryPolicy_Deadline(t *testing.T) {
+	p := &RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Second}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+	start := time.Now()
+	attempts := 0
+	err := p.Do(ctx, "Foo.Sum", func(int) error {
+		attempts++
+		return ErrShutdown
+	})
+	if err != ErrShutdown || attempts != 1 {
+		t.Fatalf("expect the last error without a retry, got %d attempts, err %v", attempts, err)
+	}
+	if time.Since(start) > time.Millisecond*50 {
+		t.Fatal("a backoff past the deadline shouldn't be waited for")
+	}
+}
+
+func TestRetryPolicy_Retryable(t *testing.T) {
+	p := &RetryPolicy{}
+	_, err := Dial("tcp", "127.0.0.1:1")
+	if !p.Retryable(err) {
+		t.Fatalf("expect a refused connection to be retryable: %v", err)
+	}
+	if !p.Retryable(fmt.Errorf("rpc client: %w", ErrConnectTimeout)) {
+		t.Fatal("expect a connect timeout to be retryable")
+	}
+	if p.Retryable(ServerError("connection refused by the database")) {
+		t.Fatal("expect errors of the server not to be retryable by their text")
+	}
+}
+
+type Pair struct{ A, B int }
+
+func TestInvoke(t *testing.T) {
+	server := service.NewServer()
+	_ = service.RegisterFunc(server, "Math.Add", func(ctx context.Context, p Pair) (int, error) {
+		return p.A + p.B, nil
+	})
+	_ = service.RegisterFunc(server, "Math.Whoami", func(ctx context.Context, _ int) (string, error) {
+		return service.MetadataFromContext(ctx)["caller"], nil
+	})
+	if err := service.RegisterFunc(server, "Math.Add", func(ctx context.Context, p Pair) (int, error) { return 0, nil }); err == nil {
+		t.Fatal("expect error registering a method twice")
+	}
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer func() { _ = l.Close() }()
+	go server.Accept(l)
+	c, err := Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = c.Close() }()
+
+	ctx := ContextWithMetadata(context.Background(), map[string]string{"caller": "test"})
+	if sum, err := Invoke[Pair, int](ctx, c, "Math.Add", Pair{A: 1, B: 2}); err != nil || sum != 3 {
+		t.Fatalf("expect 3, got %d, err %v", sum, err)
+	}
+	if caller, err := Invoke[int, string](ctx, c, "Math.Whoami", 0); err != nil || caller != "test" {
+		t.Fatalf("expect metadata passed to the function, got %q, err %v", caller, err)
+	}
+	if _, err := Invoke[Pair, int](ctx, c, "Math.Sub", Pair{}); err == nil {
+		t.Fatal("expect error calling a method not registered")
+	}
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const generatedHeader = "// Code generated by geerpc-gen. DO NOT EDIT."
+
+// rpcService is a type whose methods are registered by the server
+type rpcService struct {
+	name    string
+	methods []rpcMethod
+}
+
+type rpcMethod struct {
+	name      string
+	argType   string // as written in the source, e.g. Args or *time.Time
+	replyType string // without the pointer of the reply parameter
+	argExpr   ast.Expr
+	replyExpr ast.Expr
+	ctxExpr   *ast.SelectorExpr // the context.Context before args, nil if the method has none
+}
+
+// generate parses the package in dir and returns the source of the clients of types,
+// empty types means all services found.
+func generate(dir string, types []string) ([]byte, error) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, dir, func(fi os.FileInfo) bool {
+		return !strings.HasSuffix(fi.Name(), "_test.go")
+	}, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+	if len(pkgs) != 1 {
+		return nil, fmt.Errorf("expect one package in %s, found %d", dir, len(pkgs))
+	}
+	var pkg *ast.Package
+	for _, p := range pkgs {
+		pkg = p
+	}
+
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	names := &packageNames{dir: absDir, names: make(map[string]string)}
+	services := make(map[string]*rpcService)
+	imports := make(map[string]string) // name -> path of packages used by args and replies
+	for _, file := range pkg.Files {
+		if isGenerated(file) {
+			continue
+		}
+		for _, decl := range file.Decls {
+			fn, ok := decl.(*ast.FuncDecl)
+			if !ok || fn.Recv == nil || len(fn.Recv.List) != 1 {
+				continue
+			}
+			recv := receiverName(fn.Recv.List[0].Type)
+			if !ast.IsExported(recv) {
+				continue
+			}
+			m, ok := rpcMethodOf(fn)
+			if !ok {
+				continue
+			}
+			if m.ctxExpr != nil {
+				if path, _ := importPath(file, m.ctxExpr.X.(*ast.Ident).Name, names); path != "context" {
+					continue
+				}
+			}
+			for _, name := range append(packagesOf(m.argExpr), packagesOf(m.replyExpr)...) {
+				path, err := importPath(file, name, names)
+				if err != nil {
+					return nil, fmt.Errorf("%s.%s: %v", recv, m.name, err)
+				}
+				imports[name] = path
+			}
+			if services[recv] == nil {
+				services[recv] = &rpcService{name: recv}
+			}
+			services[recv].methods = append(services[recv].methods, m)
+		}
+	}
+
+	var selected []*rpcService
+	if len(types) == 0 {
+		for _, s := range services {
+			selected = append(selected, s)
+		}
+	} else {
+		for _, name := range types {
+			s := services[strings.TrimSpace(name)]
+			if s == nil {
+				return nil, fmt.Errorf("%s is not a service in %s", name, dir)
+			}
+			selected = append(selected, s)
+		}
+	}
+	if len(selected) == 0 {
+		return nil, errors.New("no service found in " + dir)
+	}
+	sort.Slice(selected, func(i, j int) bool { return selected[i].name < selected[j].name })
+	return render(pkg.Name, selected, imports)
+}
+
+// rpcMethodOf reports whether fn is registered by registerMethods:
+// exported, two parameters with exported or builtin types, the second a pointer, and returns error.
+// The parameters may follow a context.Context, the caller checks the package of ctxExpr.
+func rpcMethodOf(fn *ast.FuncDecl) (rpcMethod, bool) {
+	params, results := paramTypes(fn.Type.Params.List), fn.Type.Results
+	if !fn.Name.IsExported() || results == nil || fieldCount(results.List) != 1 {
+		return rpcMethod{}, false
+	}
+	if ident, ok := results.List[0].Type.(*ast.Ident); !ok || ident.Name != "error" {
+		return rpcMethod{}, false
+	}
+	var ctxExpr *ast.SelectorExpr
+	if len(params) == 3 {
+		sel, ok := params[0].(*ast.SelectorExpr)
+		if !ok || sel.Sel.Name != "Context" {
+			return rpcMethod{}, false
+		}
+		if _, ok := sel.X.(*ast.Ident); !ok {
+			return rpcMethod{}, false
+		}
+		ctxExpr, params = sel, params[1:]
+	}
+	if len(params) != 2 {
+		return rpcMethod{}, false
+	}
+	argExpr, replyExpr := params[0], params[1]
+	star, ok := replyExpr.(*ast.StarExpr)
+	if !ok || !exportedOrBuiltin(argExpr) || !exportedOrBuiltin(replyExpr) {
+		return rpcMethod{}, false
+	}
+	return rpcMethod{
+		name:      fn.Name.Name,
+		argType:   types.ExprString(argExpr),
+		replyType: types.ExprString(star.X),
+		argExpr:   argExpr,
+		replyExpr: replyExpr,
+		ctxExpr:   ctxExpr,
+	}, true
+}
+
+// paramTypes returns the type of every parameter, func (t T) M(args, reply *int) error has two
+func paramTypes(fields []*ast.Field) []ast.Expr {
+	var exprs []ast.Expr
+	for _, f := range fields {
+		exprs = append(exprs, f.Type)
+		for i := 1; i < len(f.Names); i++ {
+			exprs = append(exprs, f.Type)
+		}
+	}
+	return exprs
+}
+
+func fieldCount(fields []*ast.Field) int {
+	n := 0
+	for _, f := range fields {
+		if len(f.Names) == 0 {
+			n++
+		} else {
+			n += len(f.Names)
+		}
+	}
+	return n
+}
+
+// exportedOrBuiltin is isExportedOrBuiltinType of the service package on the syntax tree:
+// a named type must be exported or predeclared, other types are always accepted.
+func exportedOrBuiltin(expr ast.Expr) bool {
+	switch e := expr.(type) {
+	case *ast.Ident:
+		return e.IsExported() || types.Universe.Lookup(e.Name) != nil
+	case *ast.SelectorExpr:
+		return e.Sel.IsExported()
+	default:
+		return true
+	}
+}
+
+// receiverName returns T of a receiver T or *T
+func receiverName(expr ast.Expr) string {
+	if star, ok := expr.(*ast.StarExpr); ok {
+		expr = star.X
+	}
+	if ident, ok := expr.(*ast.Ident); ok {
+		return ident.Name
+	}
+	return ""
+}
+
+// packagesOf returns the names of the packages referred by expr
+func packagesOf(expr ast.Expr) []string {
+	var names []string
+	ast.Inspect(expr, func(n ast.Node) bool {
+		if sel, ok := n.(*ast.SelectorExpr); ok {
+			if ident, ok := sel.X.(*ast.Ident); ok {
+				names = append(names, ident.Name)
+			}
+			return false
+		}
+		return true
+	})
+	return names
+}
+
+// importPath returns the path of the import of file referred by name. An import without a name
+// is referred by the name of its package, which may differ from the last element of the path,
+// e.g. example.com/mod/v2 or gopkg.in/yaml.v3, so the package is loaded to know its name.
+func importPath(file *ast.File, name string, names *packageNames) (string, error) {
+	var loadErr error
+	for _, spec := range file.Imports {
+		path, _ := strconv.Unquote(spec.Path.Value)
+		if spec.Name != nil {
+			if spec.Name.Name == name {
+				return path, nil
+			}
+			continue
+		}
+		pkgName, err := names.name(path)
+		if err != nil {
+			loadErr = err
+			continue
+		}
+		if pkgName == name {
+			return path, nil
+		}
+	}
+	if loadErr != nil {
+		return "", fmt.Errorf("can't find the import of %s: %v", name, loadErr)
+	}
+	return "", fmt.Errorf("can't find the import of %s", name)
+}
+
+// packageNames loads the names of imported packages from dir, the directory of the services
+type packageNames struct {
+	dir   string
+	names map[string]string // path -> name
+}
+
+func (p *packageNames) name(path string) (string, error) {
+	if name, ok := p.names[path]; ok {
+		return name, nil
+	}
+	ctxt := build.Default
+	ctxt.Dir = p.dir // the go command resolves imports by the module of its working directory
+	pkg, err := ctxt.Import(path, p.dir, 0)
+	if err != nil {
+		return "", err
+	}
+	p.names[path] = pkg.Name
+	return pkg.Name, nil
+}
+
+func isGenerated(file *ast.File) bool {
+	for _, c := range file.Comments {
+		if c.Pos() >= file.Package {
+			break
+		}
+		if strings.Contains(c.Text(), "Code generated by geerpc-gen") {
+			return true
+		}
+	}
+	return false
+}
+
+func render(pkgName string, services []*rpcService, imports map[string]string) ([]byte, error) {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s\n\npackage %s\n\nimport (\n\t\"context\"\n\t\"geeRPC/client\"\n", generatedHeader, pkgName)
+	names := make([]string, 0, len(imports))
+	for name := range imports {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		path := imports[name]
+		if name == path[strings.LastIndex(path, "/")+1:] {
+			fmt.Fprintf(&buf, "\t%q\n", path)
+		} else {
+			fmt.Fprintf(&buf, "\t%s %q\n", name, path)
+		}
+	}
+	buf.WriteString(")\n")
+	for _, s := range services {
+		sort.Slice(s.methods, func(i, j int) bool { return s.methods[i].name < s.methods[j].name })
+		fmt.Fprintf(&buf, "\n// %[1]sClient is a typed client of the %[1]s service\n", s.name)
+		fmt.Fprintf(&buf, "type %sClient struct {\n\tc client.Caller\n}\n\n", s.name)
+		fmt.Fprintf(&buf, "// New%[1]sClient returns a client of the %[1]s service calling through c, a *client.Client or an *xclient.XClient\n", s.name)
+		fmt.Fprintf(&buf, "func New%[1]sClient(c client.Caller) *%[1]sClient {\n\treturn &%[1]sClient{c: c}\n}\n", s.name)
+		for _, m := range s.methods {
+			fmt.Fprintf(&buf, "\n// %s calls %s.%s\n", m.name, s.name, m.name)
+			fmt.Fprintf(&buf, "func (c *%sClient) %s(ctx context.Context, args %s) (%s, error) {\n", s.name, m.name, m.argType, m.replyType)
+			fmt.Fprintf(&buf, "\tvar reply %s\n", m.replyType)
+			fmt.Fprintf(&buf, "\terr := c.c.Call(ctx, %q, args, &reply)\n\treturn reply, err\n}\n", s.name+"."+m.name)
+		}
+	}
+	return format.Source(buf.Bytes())
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const servicesSrc = `package arith
+
+import (
+	"context"
+	"errors"
+	tm "time"
+)
+
+type Args struct{ Num1, Num2 int }
+
+type Arith int
+
+func (a *Arith) Multiply(args Args, reply *int) error {
+	*reply = args.Num1 * args.Num2
+	return nil
+}
+
+func (a Arith) Divide(args *Args, reply *float64) error {
+	if args.Num2 == 0 {
+		return errors.New("divide by zero")
+	}
+	*reply = float64(args.Num1) / float64(args.Num2)
+	return nil
+}
+
+func (a *Arith) Now(args int, reply *tm.Time) error { return nil }
+
+func (a *Arith) Square(args, reply *int) error { return nil }
+
+func (a *Arith) Trace(ctx context.Context, args string, reply *string) error { return nil }
+
+// not registered: unexported, wrong signatures or unexported types
+func (a *Arith) add(args Args, reply *int) error     { return nil }
+func (a *Arith) Sub(args Args, reply int) error      { return nil }
+func (a *Arith) Neg(args Args) (int, error)          { return 0, nil }
+func (a *Arith) Mod(args args, reply *int) error     { return nil }
+func (a *Arith) Wait(ctx tm.Context, args Args, reply *int) error { return nil }
+
+type args struct{}
+
+type helper int
+
+func (h helper) Help(args Args, reply *int) error { return nil }
+`
+
+func TestGenerate(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "arith.go"), []byte(servicesSrc), 0644); err != nil {
+		t.Fatal(err)
+	}
+	src, err := generate(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := string(src)
+	for _, want := range []string{
+		"package arith",
+		`tm "time"`,
+		"func NewArithClient(c client.Caller) *ArithClient",
+		"func (c *ArithClient) Divide(ctx context.Context, args *Args) (float64, error)",
+		"func (c *ArithClient) Multiply(ctx context.Context, args Args) (int, error)",
+		"func (c *ArithClient) Now(ctx context.Context, args int) (tm.Time, error)",
+		"func (c *ArithClient) Square(ctx context.Context, args *int) (int, error)",
+		"func (c *ArithClient) Trace(ctx context.Context, args string) (string, error)",
+		`c.c.Call(ctx, "Arith.Multiply", args, &reply)`,
+	} {
+		if !strings.Contains(code, want) {
+			t.Fatalf("expect %q in the generated code:\n%s", want, code)
+		}
+	}
+	for _, unwanted := range []string{"add(", "Sub(", "Neg(", "Mod(", "Wait(", "helper"} {
+		if strings.Contains(code, unwanted) {
+			t.Fatalf("unexpected %q in the generated code:\n%s", unwanted, code)
+		}
+	}
+
+	// the generated file is skipped when generating again
+	if err := os.WriteFile(filepath.Join(dir, "geerpc_client.go"), src, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if again, err := generate(dir, []string{"Arith"}); err != nil || string(again) != code {
+		t.Fatalf("expect the same code generated again, err %v", err)
+	}
+	if _, err := generate(dir, []string{"Args"}); err == nil {
+		t.Fatal("expect error generating a client of a type without RPC methods")
+	}
+}
+
+func TestGenerate_PackageName(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"go.mod":        "module example.com/app\n\ngo 1.18\n",
+		"lib/v2/lib.go": "package lib\n\ntype Point struct{ X, Y int }\n",
+		"svc/svc.go": `package svc
+
+import "example.com/app/lib/v2"
+
+type Geo int
+
+func (g *Geo) Flip(args lib.Point, reply *lib.Point) error { return nil }
+`,
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	src, err := generate(filepath.Join(dir, "svc"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := string(src)
+	for _, want := range []string{
+		`lib "example.com/app/lib/v2"`,
+		"func (c *GeoClient) Flip(ctx context.Context, args lib.Point) (lib.Point, error)",
+	} {
+		if !strings.Contains(code, want) {
+			t.Fatalf("expect %q in the generated code:\n%s", want, code)
+		}
+	}
+}
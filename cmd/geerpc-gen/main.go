@@ -0,0 +1,57 @@
+// Command geerpc-gen generates typed clients of the geeRPC services in a Go package.
+// A service is an exported type with methods that the server registers:
+//
+//	func (t *T) MethodName(args ArgType, reply *ReplyType) error
+//	func (t *T) MethodName(ctx context.Context, args ArgType, reply *ReplyType) error
+//
+// The reply must be a pointer, methods with other replies are not registered and get no client method.
+// For every service T it generates a TClient with a method per RPC method,
+//
+//	func (c *TClient) MethodName(ctx context.Context, args ArgType) (ReplyType, error)
+//
+// calling through a client.Caller, i.e. a *client.Client or an *xclient.XClient.
+// The clients are generated into the package of the services:
+//
+//	geerpc-gen -dir ./service -type Foo,Bar -o foo_client.go
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func main() {
+	dir := flag.String("dir", ".", "directory of the package to parse")
+	typeNames := flag.String("type", "", "comma separated services to generate clients for, empty means all")
+	output := flag.String("o", "geerpc_client.go", "output file, relative to dir")
+	flag.Usage = func() {
+		out := flag.CommandLine.Output()
+		_, _ = fmt.Fprintln(out, "usage: geerpc-gen [-dir dir] [-type T1,T2] [-o file]")
+		_, _ = fmt.Fprintln(out, "generates clients of the methods func (t *T) M(args A, reply *R) error, the reply must be a pointer")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	log.SetFlags(0)
+	log.SetPrefix("geerpc-gen: ")
+
+	var types []string
+	if *typeNames != "" {
+		types = strings.Split(*typeNames, ",")
+	}
+	src, err := generate(*dir, types)
+	if err != nil {
+		log.Fatal(err)
+	}
+	out := *output
+	if !filepath.IsAbs(out) {
+		out = filepath.Join(*dir, out)
+	}
+	if err := os.WriteFile(out, src, 0644); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println("generated", out)
+}
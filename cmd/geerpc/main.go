@@ -0,0 +1,168 @@
+// Command geerpc calls methods of a geeRPC server from the command line.
+// The server must enable the Reflection service, it tells the types of args and replies.
+//
+//	geerpc -addr tcp@localhost:9999 -list
+//	geerpc -addr tcp@localhost:9999 -describe Foo
+//	geerpc -addr tcp@localhost:9999 -md trace=abc Foo.Sum '{"Num1": 1, "Num2": 2}'
+//
+// Args are read from stdin if they are "-", the reply is printed as JSON.
+// Metadata set by -md is read by the methods with service.MetadataFromContext, methods registered
+// with Register see it if their first parameter is a context.Context.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"geeRPC/client"
+	"geeRPC/service"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// metadata collects repeated -md k=v flags
+type metadata map[string]string
+
+func (md metadata) String() string {
+	pairs := make([]string, 0, len(md))
+	for k, v := range md {
+		pairs = append(pairs, k+"="+v)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (md metadata) Set(s string) error {
+	kv := strings.SplitN(s, "=", 2)
+	if len(kv) != 2 || kv[0] == "" {
+		return fmt.Errorf("expect key=value, got %q", s)
+	}
+	md[kv[0]] = kv[1]
+	return nil
+}
+
+func main() {
+	md := metadata{}
+	addr := flag.String("addr", "", "address of the server, e.g. tcp@localhost:9999, http@localhost:9999, unix@/tmp/geerpc.sock")
+	timeout := flag.Duration("timeout", time.Second*10, "timeout of connecting and every call")
+	list := flag.Bool("list", false, "list services and methods")
+	describe := flag.String("describe", "", "describe the methods of Service or Service.Method")
+	flag.Var(md, "md", "metadata key=value sent with the call, can be repeated")
+	flag.Usage = func() {
+		_, _ = fmt.Fprintln(flag.CommandLine.Output(), "usage: geerpc -addr protocol@addr [flags] [Service.Method [args|-]]")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if *addr == "" || (!*list && *describe == "" && flag.NArg() == 0) {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	opt := *service.DefaultOption
+	opt.ConnectTimeout = *timeout
+	c, err := client.XDial(*addr, &opt)
+	if err != nil {
+		fatal(err)
+	}
+	defer func() { _ = c.Close() }()
+	ctx, cancel := context.WithTimeout(client.ContextWithMetadata(context.Background(), md), *timeout)
+	defer cancel()
+
+	switch {
+	case *list:
+		err = listServices(ctx, c, os.Stdout)
+	case *describe != "":
+		svc, method := splitServiceMethod(*describe)
+		err = describeService(ctx, c, os.Stdout, svc, method)
+	default:
+		args := "{}"
+		if flag.NArg() > 1 {
+			args = flag.Arg(1)
+		}
+		if args == "-" {
+			data, err := io.ReadAll(os.Stdin)
+			if err != nil {
+				fatal(err)
+			}
+			args = string(data)
+		}
+		err = invoke(ctx, c, os.Stdout, flag.Arg(0), args)
+	}
+	if err != nil {
+		fatal(err)
+	}
+}
+
+func fatal(err error) {
+	if strings.Contains(err.Error(), "can't find service Reflection") {
+		err = errors.New(err.Error() + " (the server must call EnableReflection)")
+	}
+	_, _ = fmt.Fprintln(os.Stderr, "geerpc:", err)
+	os.Exit(1)
+}
+
+func splitServiceMethod(serviceMethod string) (string, string) {
+	dot := strings.LastIndex(serviceMethod, ".")
+	if dot < 0 {
+		return serviceMethod, ""
+	}
+	return serviceMethod[:dot], serviceMethod[dot+1:]
+}
+
+func listServices(ctx context.Context, c *client.Client, w io.Writer) error {
+	var reply service.ListServicesResponse
+	if err := c.Call(ctx, "Reflection.ListServices", &service.ListServicesRequest{}, &reply); err != nil {
+		return err
+	}
+	for _, s := range reply.Services {
+		_, _ = fmt.Fprintln(w, s.Name)
+		for _, m := range s.Methods {
+			_, _ = fmt.Fprintln(w, "  "+m)
+		}
+	}
+	return nil
+}
+
+func describeService(ctx context.Context, c *client.Client, w io.Writer, svc, method string) error {
+	var reply service.ServiceDescriptor
+	if err := c.Call(ctx, "Reflection.Describe", &service.DescribeRequest{Service: svc, Method: method}, &reply); err != nil {
+		return err
+	}
+	for _, m := range reply.Methods {
+		_, _ = fmt.Fprintf(w, "%s.%s\n  args:  %s\n  reply: %s\n", reply.Name, m.Name, formatType(m.ArgType), formatType(m.ReplyType))
+	}
+	return nil
+}
+
+// invoke calls serviceMethod with args in JSON, the types are built from the descriptor of the method
+func invoke(ctx context.Context, c *client.Client, w io.Writer, serviceMethod, args string) error {
+	svc, method := splitServiceMethod(serviceMethod)
+	if method == "" {
+		return fmt.Errorf("expect Service.Method, got %q", serviceMethod)
+	}
+	var desc service.ServiceDescriptor
+	if err := c.Call(ctx, "Reflection.Describe", &service.DescribeRequest{Service: svc, Method: method}, &desc); err != nil {
+		return err
+	}
+	argType, replyType, err := methodTypes(desc.Methods[0])
+	if err != nil {
+		return fmt.Errorf("%s: %v", serviceMethod, err)
+	}
+	argv := newValue(argType)
+	if err := json.Unmarshal([]byte(args), argv.Interface()); err != nil {
+		return fmt.Errorf("invalid args of %s: %v", serviceMethod, err)
+	}
+	replyv := newValue(replyType)
+	if err := c.Call(ctx, serviceMethod, argv.Elem().Interface(), replyv.Interface()); err != nil {
+		return err
+	}
+	out, err := json.MarshalIndent(replyv.Elem().Interface(), "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(out))
+	return err
+}
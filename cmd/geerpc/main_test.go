@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"geeRPC/client"
+	"geeRPC/service"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+type Args struct{ Num1, Num2 int }
+
+type Point struct{ X, Y int }
+
+type Foo int
+
+func (f Foo) Sum(args Args, reply *int) error {
+	*reply = args.Num1 + args.Num2
+	return nil
+}
+
+type Node struct {
+	Value int
+	Next  *Node
+}
+
+func (f Foo) Walk(args Node, reply *int) error { return nil }
+
+func (f Foo) Flip(args *[]Point, reply *map[string][2]int) error {
+	for i, p := range *args {
+		(*reply)[string(rune('a'+i))] = [2]int{p.Y, p.X}
+	}
+	return nil
+}
+
+func TestInvoke(t *testing.T) {
+	server := service.NewServer()
+	var foo Foo
+	_ = server.Register(&foo)
+	_ = server.EnableReflection()
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer func() { _ = l.Close() }()
+	go server.Accept(l)
+
+	c, err := client.XDial("tcp@" + l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = c.Close() }()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	var out bytes.Buffer
+	if err := invoke(ctx, c, &out, "Foo.Sum", `{"Num1": 1, "Num2": 2}`); err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(out.String()) != "3" {
+		t.Fatalf("expect 3, got %s", out.String())
+	}
+	out.Reset()
+	if err := invoke(ctx, c, &out, "Foo.Flip", `[{"X": 1, "Y": 2}]`); err != nil {
+		t.Fatal(err)
+	}
+	if s := strings.Join(strings.Fields(out.String()), ""); s != `{"a":[2,1]}` {
+		t.Fatalf(`expect {"a":[2,1]}, got %s`, s)
+	}
+	out.Reset()
+	if err := describeService(ctx, c, &out, "Foo", "Sum"); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "main.Args struct { Num1 int; Num2 int }") {
+		t.Fatalf("unexpected description %s", out.String())
+	}
+}
+
+func TestInvoke_Unsupported(t *testing.T) {
+	server := service.NewServer()
+	var foo Foo
+	_ = server.Register(&foo)
+	_ = server.EnableReflection()
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer func() { _ = l.Close() }()
+	go server.Accept(l)
+
+	c, err := client.XDial("tcp@" + l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = c.Close() }()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	var out bytes.Buffer
+	if err := invoke(ctx, c, &out, "Foo.Walk", `{"Value": 1, "Next": {"Value": 2}}`); err == nil || !strings.Contains(err.Error(), "recursive") {
+		t.Fatalf("expect error invoking a method with a recursive type, got %v", err)
+	}
+
+	_, _, err = methodTypes(service.MethodDescriptor{
+		ArgType:   &service.TypeDescriptor{Name: "int", Kind: "int"},
+		ReplyType: &service.TypeDescriptor{Name: "int", Kind: "int"},
+	})
+	if err == nil {
+		t.Fatal("expect error with a reply type that isn't a pointer")
+	}
+}
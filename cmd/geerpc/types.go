@@ -0,0 +1,147 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"geeRPC/service"
+	"reflect"
+	"strings"
+)
+
+var basicTypes = map[string]reflect.Type{
+	"bool":       reflect.TypeOf(false),
+	"int":        reflect.TypeOf(int(0)),
+	"int8":       reflect.TypeOf(int8(0)),
+	"int16":      reflect.TypeOf(int16(0)),
+	"int32":      reflect.TypeOf(int32(0)),
+	"int64":      reflect.TypeOf(int64(0)),
+	"uint":       reflect.TypeOf(uint(0)),
+	"uint8":      reflect.TypeOf(uint8(0)),
+	"uint16":     reflect.TypeOf(uint16(0)),
+	"uint32":     reflect.TypeOf(uint32(0)),
+	"uint64":     reflect.TypeOf(uint64(0)),
+	"uintptr":    reflect.TypeOf(uintptr(0)),
+	"float32":    reflect.TypeOf(float32(0)),
+	"float64":    reflect.TypeOf(float64(0)),
+	"complex64":  reflect.TypeOf(complex64(0)),
+	"complex128": reflect.TypeOf(complex128(0)),
+	"string":     reflect.TypeOf(""),
+	"interface":  reflect.TypeOf((*interface{})(nil)).Elem(),
+}
+
+// methodTypes builds the args type and the type the reply pointer points to of m
+func methodTypes(m service.MethodDescriptor) (reflect.Type, reflect.Type, error) {
+	if m.ArgType == nil || m.ReplyType == nil {
+		return nil, nil, errors.New("the method has no args or reply type")
+	}
+	if m.ReplyType.Kind != "ptr" || m.ReplyType.Elem == nil {
+		return nil, nil, fmt.Errorf("reply type %s is not a pointer", m.ReplyType.Name)
+	}
+	argType, err := buildType(m.ArgType)
+	if err != nil {
+		return nil, nil, err
+	}
+	replyType, err := buildType(m.ReplyType.Elem)
+	if err != nil {
+		return nil, nil, err
+	}
+	return argType, replyType, nil
+}
+
+// buildType builds a type with the same structure as d. The codecs match structs by field names,
+// so a value of the built type can stand for a value of the type on the server.
+// Recursive types are not supported: their second occurrence is described without fields,
+// building it would silently drop the fields of the input.
+func buildType(d *service.TypeDescriptor) (reflect.Type, error) {
+	return build(d, make(map[string]bool))
+}
+
+// build builds d, visiting holds the names of the structs being built around d
+func build(d *service.TypeDescriptor, visiting map[string]bool) (reflect.Type, error) {
+	if t, ok := basicTypes[d.Kind]; ok {
+		return t, nil
+	}
+	switch d.Kind {
+	case "struct":
+		if visiting[d.Name] {
+			return nil, fmt.Errorf("recursive type %s is not supported", d.Name)
+		}
+		visiting[d.Name] = true
+		defer delete(visiting, d.Name)
+		fields := make([]reflect.StructField, 0, len(d.Fields))
+		for _, f := range d.Fields {
+			ft, err := build(f.Type, visiting)
+			if err != nil {
+				return nil, err
+			}
+			fields = append(fields, reflect.StructField{Name: f.Name, Type: ft, Tag: reflect.StructTag(`json:"` + f.Name + `"`)})
+		}
+		return reflect.StructOf(fields), nil
+	case "ptr", "slice", "array", "map":
+		if d.Elem == nil {
+			return nil, fmt.Errorf("type %s has no element type", d.Name)
+		}
+		elem, err := build(d.Elem, visiting)
+		if err != nil {
+			return nil, err
+		}
+		switch d.Kind {
+		case "ptr":
+			return reflect.PtrTo(elem), nil
+		case "slice":
+			return reflect.SliceOf(elem), nil
+		case "array":
+			return reflect.ArrayOf(d.Len, elem), nil
+		}
+		if d.Key == nil {
+			return nil, fmt.Errorf("map %s has no key type", d.Name)
+		}
+		key, err := build(d.Key, visiting)
+		if err != nil {
+			return nil, err
+		}
+		return reflect.MapOf(key, elem), nil
+	default:
+		return nil, fmt.Errorf("type %s of kind %s is not supported", d.Name, d.Kind)
+	}
+}
+
+// newValue returns a pointer to a new value of t, pointers, maps and slices in it are allocated
+// the same way as the server allocates replies.
+func newValue(t reflect.Type) reflect.Value {
+	v := reflect.New(t)
+	switch t.Kind() {
+	case reflect.Ptr:
+		v.Elem().Set(reflect.New(t.Elem()))
+	case reflect.Map:
+		v.Elem().Set(reflect.MakeMap(t))
+	case reflect.Slice:
+		v.Elem().Set(reflect.MakeSlice(t, 0, 0))
+	}
+	return v
+}
+
+// formatType renders d like a Go type, e.g. main.Args struct { Num1 int; Num2 int }
+func formatType(d *service.TypeDescriptor) string {
+	switch d.Kind {
+	case "struct":
+		if len(d.Fields) == 0 {
+			return d.Name
+		}
+		fields := make([]string, 0, len(d.Fields))
+		for _, f := range d.Fields {
+			fields = append(fields, f.Name+" "+formatType(f.Type))
+		}
+		return d.Name + " struct { " + strings.Join(fields, "; ") + " }"
+	case "ptr":
+		return "*" + formatType(d.Elem)
+	case "slice":
+		return "[]" + formatType(d.Elem)
+	case "array":
+		return fmt.Sprintf("[%d]%s", d.Len, formatType(d.Elem))
+	case "map":
+		return "map[" + formatType(d.Key) + "]" + formatType(d.Elem)
+	default:
+		return d.Name
+	}
+}
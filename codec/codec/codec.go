@@ -8,9 +8,10 @@ import "io"
 // 服务端的响应包括: 1. 错误error 2. 返回值 reply
 // 我们将请求和响应中的参数和返回值抽象为body， 剩余的信息放在header中，那么就可以抽象出数据结构 Header：
 type Header struct {
-	ServiceMethod string // 服务名和方法名，与Go语言中的结构体和方法相映射。format "Service.Method"
-	Seq           uint64 // 请求的序号，也可以认为是某个请求的 ID，用来区分不同的请求 sequence number chosen by client
-	Error         string // 客户端置为空，服务端如果如果发生错误，将错误信息置于 Error 中
+	ServiceMethod string            // 服务名和方法名，与Go语言中的结构体和方法相映射。format "Service.Method"
+	Seq           uint64            // 请求的序号，也可以认为是某个请求的 ID，用来区分不同的请求 sequence number chosen by client
+	Error         string            // 客户端置为空，服务端如果如果发生错误，将错误信息置于 Error 中
+	Metadata      map[string]string // 客户端随请求发送的元数据，例如链路追踪的 ID，响应中为空，服务端通过方法的 ctx 传递
 }
 
 // Codec 抽象出对消息体进行编解码的接口 Codec
@@ -0,0 +1,191 @@
+package registry
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// syncEntry is the state of a server exchanged between registries of a cluster
+type syncEntry struct {
+	Addr      string
+	Namespace string      `json:",omitempty"`
+	Item      *ServerItem `json:",omitempty"`
+	Time      time.Time   // the last heartbeat of a server, or when a server was deregistered
+	Deleted   bool        // a tombstone, so that peers don't bring a deregistered server back
+}
+
+const defaultSyncInterval = time.Second * 5
+
+// WithPeers makes the registry a member of a cluster that replicates registrations by anti-entropy:
+// every interval the registry sends its state to each peer, the peer merges it and replies with its
+// own state to be merged back. Concurrent changes of a server are resolved by last write wins,
+// so the clocks of the registries should be roughly in sync. Peers are registry URLs,
+// e.g. http://localhost:9999/_geerpc_/registry. Close stops the replication.
+func WithPeers(peers []string, interval time.Duration) Option {
+	return func(r *GeeRegistry) {
+		if interval <= 0 {
+			interval = defaultSyncInterval
+		}
+		r.peers = peers
+		r.syncInterval = interval
+	}
+}
+
+// gossip syncs with every peer each interval until the registry is closed
+func (r *GeeRegistry) gossip() {
+	t := time.NewTicker(r.syncInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-r.done:
+			return
+		case <-t.C:
+		}
+		for _, peer := range r.peers {
+			if err := r.syncWith(peer); err != nil {
+				log.Println("rpc registry: sync with", peer, "err:", err)
+			}
+		}
+	}
+}
+
+// syncWith exchanges the state with peer
+func (r *GeeRegistry) syncWith(peer string) error {
+	data, err := json.Marshal(r.syncState())
+	if err != nil {
+		return err
+	}
+	req, _ := http.NewRequest("POST", peer, bytes.NewReader(data))
+	req.Header.Set("X-Geerpc-Sync", "1")
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := r.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	var entries []syncEntry
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return err
+	}
+	r.merge(entries)
+	return nil
+}
+
+// serveSync merges the state sent by a peer and replies with the state of r
+func (r *GeeRegistry) serveSync(w http.ResponseWriter, req *http.Request) {
+	var entries []syncEntry
+	if err := json.NewDecoder(req.Body).Decode(&entries); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	r.merge(entries)
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(r.syncState())
+}
+
+// syncState returns the servers confirmed by heartbeats and the tombstones,
+// tombstones older than the timeout are dropped, no peer can hold a live server older than them.
+func (r *GeeRegistry) syncState() []syncEntry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	entries := make([]syncEntry, 0, len(r.servers)+len(r.tombstones))
+	for k, s := range r.servers {
+		if !s.stale {
+			entries = append(entries, syncEntry{Addr: k.addr, Namespace: k.namespace, Item: s, Time: s.start})
+		}
+	}
+	r.pruneTombstones()
+	for k, t := range r.tombstones {
+		entries = append(entries, syncEntry{Addr: k.addr, Namespace: k.namespace, Time: t, Deleted: true})
+	}
+	return entries
+}
+
+// merge applies the entries of a peer that are newer than the local state
+func (r *GeeRegistry) merge(entries []syncEntry) {
+	r.mu.Lock()
+	defer r.syncStore() // runs after r.mu is unlocked
+	defer r.mu.Unlock()
+	for _, e := range entries {
+		k := newServerKey(e.Namespace, e.Addr)
+		if t, ok := r.tombstones[k]; ok && !e.Time.After(t) {
+			continue
+		}
+		s := r.servers[k]
+		if s != nil && !s.stale && !e.Time.After(s.start) {
+			continue
+		}
+		if e.Deleted {
+			r.tombstones[k] = e.Time
+			if s != nil {
+				delete(r.servers, k)
+				r.changed()
+				r.persist(storeRecord{Op: "del", Addr: k.addr, Namespace: k.namespace})
+			}
+			continue
+		}
+		if e.Item == nil || (r.timeout > 0 && !e.Time.Add(r.timeout).After(time.Now())) {
+			continue // expired on the peer as well, it just hasn't noticed yet
+		}
+		delete(r.tombstones, k)
+		item := e.Item
+		item.Addr, item.Namespace, item.start = e.Addr, e.Namespace, e.Time
+		switch {
+		case s == nil || !reflect.DeepEqual(s.meta(), item.meta()):
+			if s != nil {
+				// health is probed by every registry itself
+				item.probeFailures, item.unhealthy = s.probeFailures, s.unhealthy
+			}
+			r.servers[k] = item
+			r.changed()
+			r.persist(storeRecord{Op: "put", Item: item})
+		case s.stale:
+			s.start, s.stale = e.Time, false
+			r.changed()
+		default:
+			s.start = e.Time // a heartbeat received by the peer
+		}
+	}
+}
+
+func (r *GeeRegistry) tombstoneTTL() time.Duration {
+	if r.timeout > 0 {
+		return r.timeout
+	}
+	return defaultTimeout
+}
+
+// pruneTombstones drops the tombstones older than tombstoneTTL, r.mu must be held
+func (r *GeeRegistry) pruneTombstones() {
+	for k, t := range r.tombstones {
+		if time.Since(t) > r.tombstoneTTL() {
+			delete(r.tombstones, k)
+		}
+	}
+}
+
+// Close stops replicating with peers and probing servers
+func (r *GeeRegistry) Close() error {
+	r.closeOnce.Do(func() { close(r.done) })
+	return nil
+}
+
+// SplitRegistries splits a comma separated list of registry URLs, e.g. the registry argument of Heartbeat
+func SplitRegistries(registry string) []string {
+	var addrs []string
+	for _, addr := range strings.Split(registry, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
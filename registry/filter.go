@@ -0,0 +1,111 @@
+package registry
+
+import (
+	"net/url"
+	"time"
+)
+
+// ServerFilter selects servers by their metadata, the zero value selects all servers of DefaultNamespace
+type ServerFilter struct {
+	Namespace string   // a server must be in the namespace, empty means DefaultNamespace and AnyNamespace any one
+	Tags      []string // a server must have all the tags
+	Service   string   // a server must provide the service
+}
+
+// FilterFromQuery parses ?ns=prod&tag=a&tag=b&service=Arith
+func FilterFromQuery(q url.Values) ServerFilter {
+	return ServerFilter{Namespace: q.Get("ns"), Tags: q["tag"], Service: q.Get("service")}
+}
+
+// Values encodes the filter as URL query parameters, the reverse of FilterFromQuery
+func (f ServerFilter) Values() url.Values {
+	q := url.Values{}
+	if f.Namespace != "" {
+		q.Set("ns", f.Namespace)
+	}
+	for _, tag := range f.Tags {
+		q.Add("tag", tag)
+	}
+	if f.Service != "" {
+		q.Set("service", f.Service)
+	}
+	return q
+}
+
+// AnyNamespace is the namespace of a ServerFilter, or ?ns=*, that selects servers of all namespaces
+const AnyNamespace = "*"
+
+// Match reports whether the server is selected by f
+func (f ServerFilter) Match(item *ServerItem) bool {
+	ns := f.Namespace
+	if ns == "" {
+		ns = DefaultNamespace
+	}
+	if ns != AnyNamespace && ns != item.namespace() {
+		return false
+	}
+	for _, tag := range f.Tags {
+		if !contains(item.Tags, tag) {
+			return false
+		}
+	}
+	return f.Service == "" || contains(item.Services, f.Service)
+}
+
+// Filter returns the servers selected by f
+func (f ServerFilter) Filter(items []*ServerItem) []*ServerItem {
+	if f.Namespace == AnyNamespace && len(f.Tags) == 0 && f.Service == "" {
+		return items
+	}
+	var selected []*ServerItem
+	for _, item := range items {
+		if f.Match(item) {
+			selected = append(selected, item)
+		}
+	}
+	return selected
+}
+
+func contains(values []string, v string) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
+
+// meta returns a copy of the server without its registration state, to compare metadata
+func (item *ServerItem) meta() ServerItem {
+	m := *item
+	m.start = time.Time{}
+	m.stale = false
+	m.probeFailures, m.unhealthy = 0, false
+	return m
+}
+
+// namespace returns the namespace of the server, DefaultNamespace if it isn't set
+func (item *ServerItem) namespace() string {
+	if item.Namespace == "" {
+		return DefaultNamespace
+	}
+	return item.Namespace
+}
+
+// serverKey identifies a server in the registry
+type serverKey struct {
+	namespace string
+	addr      string
+}
+
+// newServerKey returns the key of the server at addr in namespace, empty means DefaultNamespace
+func newServerKey(namespace, addr string) serverKey {
+	if namespace == "" {
+		namespace = DefaultNamespace
+	}
+	return serverKey{namespace: namespace, addr: addr}
+}
+
+func (item *ServerItem) key() serverKey {
+	return newServerKey(item.Namespace, item.Addr)
+}
@@ -0,0 +1,83 @@
+package registry
+
+import (
+	"sync"
+	"time"
+)
+
+// Prober checks the server at addr within timeout, an error fails the probe.
+// xclient.ProbeHealth is a Prober calling the Health service of geeRPC servers.
+type Prober func(addr string, timeout time.Duration) error
+
+// WithHealthProbe makes the registry probe every registered server each interval, e.g. with
+// xclient.ProbeHealth. A server failing failures probes in a row is unhealthy: it is excluded
+// from the servers returned to clients until a probe succeeds again, even if its heartbeats keep coming.
+func WithHealthProbe(probe Prober, interval, timeout time.Duration, failures int) Option {
+	return func(r *GeeRegistry) {
+		if failures <= 0 {
+			failures = 1
+		}
+		r.probe = probe
+		r.probeInterval = interval
+		r.probeTimeout = timeout
+		r.probeFailures = failures
+	}
+}
+
+// probeLoop probes all servers each interval until the registry is closed
+func (r *GeeRegistry) probeLoop() {
+	t := time.NewTicker(r.probeInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-r.done:
+			return
+		case <-t.C:
+		}
+		r.probeAll()
+	}
+}
+
+// probeAll probes all servers confirmed by heartbeats concurrently
+func (r *GeeRegistry) probeAll() {
+	r.mu.Lock()
+	keys := make([]serverKey, 0, len(r.servers))
+	for k, s := range r.servers {
+		if !s.stale {
+			keys = append(keys, k)
+		}
+	}
+	r.mu.Unlock()
+	var wg sync.WaitGroup
+	for _, k := range keys {
+		wg.Add(1)
+		go func(k serverKey) {
+			defer wg.Done()
+			r.onProbe(k, r.probe(k.addr, r.probeTimeout))
+		}(k)
+	}
+	wg.Wait()
+}
+
+// onProbe records the result of a probe, a change of health is a change of servers
+func (r *GeeRegistry) onProbe(k serverKey, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := r.servers[k]
+	if s == nil {
+		return
+	}
+	if err == nil {
+		s.probeFailures = 0
+		if s.unhealthy {
+			s.unhealthy = false
+			r.changed()
+		}
+		return
+	}
+	s.probeFailures++
+	if !s.unhealthy && s.probeFailures >= r.probeFailures {
+		s.unhealthy = true
+		r.changed()
+	}
+}
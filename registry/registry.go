@@ -1,9 +1,16 @@
 package registry
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"reflect"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -15,69 +22,313 @@ import (
 type GeeRegistry struct {
 	timeout time.Duration
 	mu      sync.Mutex // protect following
-	servers map[string]*ServerItem
+	servers map[serverKey]*ServerItem
+	index   uint64        // version of servers, increased on every change
+	notify  chan struct{} // closed and replaced on every change to wake up watchers
+	store   *Store        // nil means registrations are kept in memory only
+
+	peers        []string                // other registries of the cluster
+	syncInterval time.Duration           // how often to sync with peers
+	tombstones   map[serverKey]time.Time // deregistered servers, to be replicated to peers
+	httpClient   *http.Client
+
+	probe         Prober
+	probeInterval time.Duration // 0 means servers are not probed
+	probeTimeout  time.Duration
+	probeFailures int // failed probes in a row to mark a server unhealthy
+
+	done      chan struct{} // closed by Close
+	closeOnce sync.Once
+}
+
+// Option configures a GeeRegistry
+type Option func(r *GeeRegistry)
+
+// WithStore persists registrations in s and restores the servers saved in it.
+// Restored servers are stale: they are not returned to clients until their next heartbeat,
+// and are removed like any other server if it doesn't come within the timeout.
+func WithStore(s *Store) Option {
+	return func(r *GeeRegistry) {
+		r.store = s
+		now := time.Now()
+		for _, item := range s.take() {
+			item.start = now
+			item.stale = true
+			r.servers[item.key()] = item
+		}
+	}
 }
 
+// ServerItem is a registered server and its metadata.
+// A server is identified by its namespace and address, a server serving several namespaces
+// registers once in each of them.
 type ServerItem struct {
-	Addr  string
-	start time.Time
+	Addr      string
+	Namespace string // e.g. "prod" or "staging", empty means DefaultNamespace
+	Version   string
+	Zone      string
+	Weight    int
+	Tags      []string
+	Services  []string // services the server provides, e.g. "Arith"
+	Meta      map[string]string
+	start     time.Time
+	stale     bool // restored from the store and not confirmed by a heartbeat yet
+
+	probeFailures int  // failed health probes in a row
+	unhealthy     bool // failed too many health probes
 }
 
+// DefaultNamespace is the namespace of servers registered without one
+const DefaultNamespace = "default"
+
 const (
 	defaultPath    = "/_geerpc_/registry"
 	defaultTimeout = time.Minute * 5
+	maxWatchWait   = time.Minute * 5
 )
 
 // New create a registry instance with timeout setting
-func New(timeout time.Duration) *GeeRegistry {
-	return &GeeRegistry{
-		servers: make(map[string]*ServerItem),
-		timeout: timeout,
+func New(timeout time.Duration, opts ...Option) *GeeRegistry {
+	r := &GeeRegistry{
+		servers:    make(map[serverKey]*ServerItem),
+		timeout:    timeout,
+		index:      1, // watchers start with index 0, so the first watch returns at once
+		notify:     make(chan struct{}),
+		tombstones: make(map[serverKey]time.Time),
+		httpClient: &http.Client{Timeout: time.Second * 10},
+		done:       make(chan struct{}),
 	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	if len(r.peers) > 0 {
+		go r.gossip()
+	}
+	if r.probe != nil && r.probeInterval > 0 {
+		go r.probeLoop()
+	}
+	return r
 }
 
 var DefaultGeeRegister = New(defaultTimeout)
 
-func (r *GeeRegistry) putServer(addr string) {
+// putServer registers the server or keeps it alive. With withMeta the metadata of item replaces
+// the metadata registered before, even if item has none, otherwise the metadata is kept.
+func (r *GeeRegistry) putServer(item *ServerItem, withMeta bool) {
 	r.mu.Lock()
+	defer r.syncStore() // runs after r.mu is unlocked
 	defer r.mu.Unlock()
-	s := r.servers[addr]
+	k := item.key()
+	s := r.servers[k]
 	if s == nil {
-		r.servers[addr] = &ServerItem{Addr: addr, start: time.Now()}
+		item.start = time.Now()
+		r.servers[k] = item
+		delete(r.tombstones, k)
+		r.changed()
+		r.persist(storeRecord{Op: "put", Item: item})
 	} else {
 		s.start = time.Now() // if exists, update start time to keep alive
+		if withMeta && !reflect.DeepEqual(s.meta(), item.meta()) {
+			item.start = s.start
+			item.probeFailures, item.unhealthy = s.probeFailures, s.unhealthy
+			r.servers[k] = item
+			r.changed()
+			r.persist(storeRecord{Op: "put", Item: item})
+		} else if s.stale {
+			s.stale = false
+			r.changed()
+		}
+	}
+}
+
+// removeServer deletes the server at once, it reports whether the server was registered
+func (r *GeeRegistry) removeServer(k serverKey) bool {
+	r.mu.Lock()
+	defer r.syncStore() // runs after r.mu is unlocked
+	defer r.mu.Unlock()
+	if _, ok := r.servers[k]; !ok {
+		return false
+	}
+	delete(r.servers, k)
+	r.changed()
+	r.persist(storeRecord{Op: "del", Addr: k.addr, Namespace: k.namespace})
+	// tombstones are kept without peers as well, a registry may be the peer of others
+	r.pruneTombstones()
+	r.tombstones[k] = time.Now()
+	return true
+}
+
+// persist writes rec to the store and compacts it when needed, r.mu must be held.
+// The record is flushed to disk by syncStore after r.mu is unlocked.
+func (r *GeeRegistry) persist(rec storeRecord) {
+	if r.store == nil {
+		return
+	}
+	compact, err := r.store.append(rec)
+	if err != nil {
+		log.Println("rpc registry: persist err:", err)
+		return
+	}
+	if compact {
+		items := make([]*ServerItem, 0, len(r.servers))
+		for _, s := range r.servers {
+			items = append(items, s)
+		}
+		if err := r.store.compact(items); err != nil {
+			log.Println("rpc registry: compact err:", err)
+		}
+	}
+}
+
+// syncStore flushes the records written by persist to disk, r.mu must not be held.
+// Expired servers are not synced by themselves, a lost del only restores a stale server that expires again.
+func (r *GeeRegistry) syncStore() {
+	if r.store == nil {
+		return
+	}
+	if err := r.store.sync(); err != nil {
+		log.Println("rpc registry: sync store err:", err)
 	}
 }
 
-func (r *GeeRegistry) aliveServers() []string {
+// changed increases the index and wakes up watchers, r.mu must be held
+func (r *GeeRegistry) changed() {
+	r.index++
+	close(r.notify)
+	r.notify = make(chan struct{})
+}
+
+// aliveServers deletes dead servers and returns alive servers sorted by address and namespace with the current index,
+// stale and unhealthy servers are kept but not returned.
+func (r *GeeRegistry) aliveServers() ([]*ServerItem, uint64) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
-	var alive []string
-	for addr, s := range r.servers {
+	var alive []*ServerItem
+	for k, s := range r.servers {
 		if r.timeout == 0 || s.start.Add(r.timeout).After(time.Now()) {
-			alive = append(alive, addr)
+			if !s.stale && !s.unhealthy {
+				alive = append(alive, s)
+			}
 		} else {
-			delete(r.servers, addr)
+			delete(r.servers, k)
+			r.changed()
+			r.persist(storeRecord{Op: "del", Addr: k.addr, Namespace: k.namespace})
+		}
+	}
+	sort.Slice(alive, func(i, j int) bool {
+		if alive[i].Addr != alive[j].Addr {
+			return alive[i].Addr < alive[j].Addr
+		}
+		return alive[i].namespace() < alive[j].namespace()
+	})
+	return alive, r.index
+}
+
+// watch blocks until the index differs from index, wait has passed or ctx is done,
+// and returns alive servers with the current index.
+func (r *GeeRegistry) watch(ctx context.Context, index uint64, wait time.Duration) ([]*ServerItem, uint64) {
+	deadline := time.Now().Add(wait)
+	for {
+		alive, current := r.aliveServers()
+		if current != index || !time.Now().Before(deadline) {
+			return alive, current
+		}
+		r.mu.Lock()
+		notify := r.notify
+		timeout := time.Until(deadline)
+		if r.timeout > 0 {
+			// wake up when the next server expires, it changes the index
+			for _, s := range r.servers {
+				if d := time.Until(s.start.Add(r.timeout)); d < timeout {
+					timeout = d
+				}
+			}
 		}
+		r.mu.Unlock()
+		t := time.NewTimer(timeout)
+		select {
+		case <-notify:
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return alive, current
+		}
+		t.Stop()
 	}
-	sort.Strings(alive)
-	return alive
 }
 
 // Runs at /_geerpc_/registry
+// Servers are returned in the X-Geerpc-Servers header, or as a JSON array of ServerItem
+// if the request accepts application/json. ?ns=, ?tag= and ?service= filter the servers,
+// e.g. /_geerpc_/registry?ns=prod&service=Arith returns the servers of Arith in prod.
+// Without ?ns= only the servers of DefaultNamespace are returned, ?ns=* returns all namespaces.
+// A server registers itself by the X-Geerpc-Server header, or by a JSON ServerItem body with its metadata.
+// A JSON body replaces the metadata registered before, so a body without tags clears the tags,
+// the header alone only keeps the server alive.
+// DELETE with the X-Geerpc-Server header deregisters the server from the namespace
+// in the X-Geerpc-Namespace header, DefaultNamespace if it isn't set.
 func (r *GeeRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	switch req.Method {
 	case "GET":
-		// keep it simple, server is in req.Header
-		w.Header().Set("X-Geerpc-Servers", strings.Join(r.aliveServers(), ","))
+		// with ?index=N, it is a long poll that returns once the index isn't N or ?wait= has passed
+		var alive []*ServerItem
+		var index uint64
+		q := req.URL.Query()
+		if q.Get("index") != "" {
+			last, err := strconv.ParseUint(q.Get("index"), 10, 64)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			wait, err := time.ParseDuration(q.Get("wait"))
+			if err != nil || wait <= 0 || wait > maxWatchWait {
+				wait = maxWatchWait
+			}
+			alive, index = r.watch(req.Context(), last, wait)
+		} else {
+			alive, index = r.aliveServers()
+		}
+		alive = FilterFromQuery(q).Filter(alive)
+		addrs := make([]string, 0, len(alive))
+		for i, s := range alive {
+			if i == 0 || s.Addr != alive[i-1].Addr { // a server in several namespaces is listed once
+				addrs = append(addrs, s.Addr)
+			}
+		}
+		w.Header().Set("X-Geerpc-Servers", strings.Join(addrs, ","))
+		w.Header().Set("X-Geerpc-Index", strconv.FormatUint(index, 10))
+		if strings.Contains(req.Header.Get("Accept"), "application/json") {
+			w.Header().Set("Content-Type", "application/json")
+			if alive == nil {
+				alive = []*ServerItem{}
+			}
+			_ = json.NewEncoder(w).Encode(alive)
+		}
 	case "POST":
-		// keep it simple, server is in req.Header
+		if req.Header.Get("X-Geerpc-Sync") != "" {
+			r.serveSync(w, req)
+			return
+		}
+		item := &ServerItem{Addr: req.Header.Get("X-Geerpc-Server")}
+		withMeta := strings.Contains(req.Header.Get("Content-Type"), "application/json")
+		if withMeta {
+			if err := json.NewDecoder(req.Body).Decode(item); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+		}
+		if item.Addr == "" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		r.putServer(item, withMeta)
+	case "DELETE":
 		addr := req.Header.Get("X-Geerpc-Server")
 		if addr == "" {
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
-		r.putServer(addr)
+		r.removeServer(newServerKey(req.Header.Get("X-Geerpc-Namespace"), addr))
 	default:
 		w.WriteHeader(http.StatusMethodNotAllowed)
 	}
@@ -93,33 +344,128 @@ func HandleHTTP() {
 	DefaultGeeRegister.HandleHTTP(defaultPath)
 }
 
+// serverClient is used by servers to send heartbeats and deregister, a registry that doesn't respond
+// must not block a server forever
+var serverClient = &http.Client{Timeout: time.Second * 10}
+
+// HeartbeatHandle is returned by Heartbeat to stop the heartbeat of a server
+type HeartbeatHandle struct {
+	registry string
+	item     *ServerItem
+	cancel   context.CancelFunc // called by Stop, it aborts the heartbeat in flight
+	done     chan struct{}      // closed when the heartbeat goroutine exits
+}
+
+// Stop stops sending heartbeats and deregisters the server, it should be called on server shutdown
+// so that clients stop dialing the server at once.
+func (h *HeartbeatHandle) Stop() error {
+	h.cancel()
+	<-h.done // a heartbeat in flight must not register the server again after Deregister
+	return DeregisterItem(h.registry, h.item)
+}
+
 // Heartbeat send a heartbeat message every once in a while
-// it's a helper function for a server to register or send heartbeat
-func Heartbeat(registry, addr string, duration time.Duration) {
+// it's a helper function for a server to register or send heartbeat.
+// registry may be a comma separated list of registries of a cluster, heartbeats go to the first one
+// that is reachable.
+func Heartbeat(registry, addr string, duration time.Duration) *HeartbeatHandle {
+	return heartbeat(registry, &ServerItem{Addr: addr}, false, duration)
+}
+
+// HeartbeatItem is the same as Heartbeat, but registers the server with its metadata.
+// Every heartbeat replaces the metadata in the registry, so an item without tags clears
+// the tags registered before.
+func HeartbeatItem(registry string, item *ServerItem, duration time.Duration) *HeartbeatHandle {
+	return heartbeat(registry, item, true, duration)
+}
+
+func heartbeat(registry string, item *ServerItem, withMeta bool, duration time.Duration) *HeartbeatHandle {
 	if duration == 0 {
 		// make sure there is enough time to send heart beat
 		// before it's removed from registry
 		duration = defaultTimeout - time.Duration(1)*time.Minute
 	}
+	ctx, cancel := context.WithCancel(context.Background())
+	h := &HeartbeatHandle{registry: registry, item: item, cancel: cancel, done: make(chan struct{})}
 	var err error
-	err = sendHeartbeat(registry, addr)
+	err = sendHeartbeat(ctx, registry, item, withMeta)
 	go func() {
+		defer close(h.done)
 		t := time.NewTicker(duration)
+		defer t.Stop()
 		for err == nil {
-			<-t.C
-			err = sendHeartbeat(registry, addr)
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+			}
+			err = sendHeartbeat(ctx, registry, item, withMeta)
 		}
 	}()
+	return h
+}
+
+// Deregister removes the server from the registry at once, instead of waiting for its heartbeat to go stale
+func Deregister(registry, addr string) error {
+	return DeregisterItem(registry, &ServerItem{Addr: addr})
 }
 
-func sendHeartbeat(registry, addr string) error {
-	log.Println(addr, "send heart beat to registry", registry)
-	httpClient := &http.Client{}
-	req, _ := http.NewRequest("POST", registry, nil)
-	req.Header.Set("X-Geerpc-Server", addr)
-	if _, err := httpClient.Do(req); err != nil {
+// DeregisterItem is the same as Deregister, but removes the server from the namespace of item
+func DeregisterItem(registry string, item *ServerItem) error {
+	var err error
+	for _, reg := range SplitRegistries(registry) {
+		if err = deregister(reg, item); err == nil {
+			return nil
+		}
+		log.Println("rpc server: deregister err:", err)
+	}
+	return err
+}
+
+func deregister(registry string, item *ServerItem) error {
+	req, _ := http.NewRequest("DELETE", registry, nil)
+	req.Header.Set("X-Geerpc-Server", item.Addr)
+	if item.Namespace != "" {
+		req.Header.Set("X-Geerpc-Namespace", item.Namespace)
+	}
+	resp, err := serverClient.Do(req)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("rpc server: deregister %s: %s", item.Addr, resp.Status)
+	}
+	return nil
+}
+
+func sendHeartbeat(ctx context.Context, registry string, item *ServerItem, withMeta bool) error {
+	var err error
+	for _, reg := range SplitRegistries(registry) {
+		if err = sendHeartbeatTo(ctx, reg, item, withMeta); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+func sendHeartbeatTo(ctx context.Context, registry string, item *ServerItem, withMeta bool) error {
+	log.Println(item.Addr, "send heart beat to registry", registry)
+	var body io.Reader
+	if withMeta {
+		data, _ := json.Marshal(item)
+		body = bytes.NewReader(data)
+	}
+	req, _ := http.NewRequestWithContext(ctx, "POST", registry, body)
+	req.Header.Set("X-Geerpc-Server", item.Addr)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := serverClient.Do(req)
+	if err != nil {
 		log.Println("rpc server: heart beat err:", err)
 		return err
 	}
+	_ = resp.Body.Close()
 	return nil
 }
@@ -0,0 +1,318 @@
+package registry
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// getServers returns the X-Geerpc-Servers of the registry at url with query
+func getServers(t *testing.T, url, query string) string {
+	resp, err := http.Get(url + query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	return resp.Header.Get("X-Geerpc-Servers")
+}
+
+func TestGeeRegistry_Filter(t *testing.T) {
+	ts := httptest.NewServer(New(time.Minute))
+	defer ts.Close()
+	HeartbeatItem(ts.URL, &ServerItem{Addr: "tcp@127.0.0.1:9997", Tags: []string{"canary", "ssd"}}, time.Minute)
+	HeartbeatItem(ts.URL, &ServerItem{Addr: "tcp@127.0.0.1:9998", Tags: []string{"ssd"}, Services: []string{"Foo"}}, time.Minute)
+	Heartbeat(ts.URL, "tcp@127.0.0.1:9999", time.Minute)
+
+	for query, expect := range map[string]string{
+		"":                     "tcp@127.0.0.1:9997,tcp@127.0.0.1:9998,tcp@127.0.0.1:9999",
+		"?tag=canary":          "tcp@127.0.0.1:9997",
+		"?tag=ssd":             "tcp@127.0.0.1:9997,tcp@127.0.0.1:9998",
+		"?tag=ssd&tag=canary":  "tcp@127.0.0.1:9997",
+		"?service=Foo":         "tcp@127.0.0.1:9998",
+		"?service=Foo&tag=ssd": "tcp@127.0.0.1:9998",
+		"?service=Bar":         "",
+		"?tag=canary&index=0":  "tcp@127.0.0.1:9997",
+	} {
+		if servers := getServers(t, ts.URL, query); servers != expect {
+			t.Fatalf("expect %q for %q, got %q", expect, query, servers)
+		}
+	}
+}
+
+func TestGeeRegistry_Metadata(t *testing.T) {
+	ts := httptest.NewServer(New(time.Minute))
+	defer ts.Close()
+	addr := "tcp@127.0.0.1:9999"
+	HeartbeatItem(ts.URL, &ServerItem{Addr: addr, Tags: []string{"canary"}}, time.Minute)
+	if servers := getServers(t, ts.URL, "?tag=canary"); servers != addr {
+		t.Fatalf("expect the server tagged, got %q", servers)
+	}
+	Heartbeat(ts.URL, addr, time.Minute)
+	if servers := getServers(t, ts.URL, "?tag=canary"); servers != addr {
+		t.Fatalf("a heartbeat without a body shouldn't clear the tags, got %q", servers)
+	}
+	HeartbeatItem(ts.URL, &ServerItem{Addr: addr}, time.Minute)
+	if servers := getServers(t, ts.URL, "?tag=canary"); servers != "" {
+		t.Fatalf("an item without tags should clear the tags, got %q", servers)
+	}
+	if servers := getServers(t, ts.URL, ""); servers != addr {
+		t.Fatalf("expect the server still registered, got %q", servers)
+	}
+}
+
+func TestGeeRegistry_Namespace(t *testing.T) {
+	s, err := OpenStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := New(time.Minute, WithStore(s))
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+	addr := "tcp@127.0.0.1:9999"
+	HeartbeatItem(ts.URL, &ServerItem{Addr: addr, Namespace: "prod"}, time.Minute)
+	HeartbeatItem(ts.URL, &ServerItem{Addr: addr, Namespace: "staging"}, time.Minute)
+	Heartbeat(ts.URL, "tcp@127.0.0.1:9998", time.Minute)
+
+	for query, expect := range map[string]string{
+		"":                        "tcp@127.0.0.1:9998",
+		"?ns=" + DefaultNamespace: "tcp@127.0.0.1:9998",
+		"?ns=prod":                addr,
+		"?ns=staging":             addr,
+		"?ns=" + AnyNamespace:     "tcp@127.0.0.1:9998," + addr,
+	} {
+		if servers := getServers(t, ts.URL, query); servers != expect {
+			t.Fatalf("expect %q for %q, got %q", expect, query, servers)
+		}
+	}
+	if err := DeregisterItem(ts.URL, &ServerItem{Addr: addr, Namespace: "prod"}); err != nil {
+		t.Fatal(err)
+	}
+	if servers := getServers(t, ts.URL, "?ns=prod"); servers != "" {
+		t.Fatalf("expect the server deregistered from prod, got %q", servers)
+	}
+	if servers := getServers(t, ts.URL, "?ns=staging"); servers != addr {
+		t.Fatalf("expect the server kept in staging, got %q", servers)
+	}
+	_ = s.Close()
+
+	s, err = OpenStore(s.dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = s.Close() }()
+	restored := s.take()
+	if len(restored) != 2 || restored[0].Addr != "tcp@127.0.0.1:9998" || restored[1].namespace() != "staging" {
+		t.Fatalf("expect the servers restored in their namespaces, got %v", restored)
+	}
+}
+
+func TestHeartbeatHandle_Stop(t *testing.T) {
+	r := New(time.Minute)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+	h := Heartbeat(ts.URL, "tcp@127.0.0.1:9999", time.Millisecond*10)
+	if alive, _ := r.aliveServers(); len(alive) != 1 {
+		t.Fatalf("expect the server registered, got %v", alive)
+	}
+	if err := h.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if alive, _ := r.aliveServers(); len(alive) != 0 {
+		t.Fatalf("expect the server deregistered at once, got %v", alive)
+	}
+	time.Sleep(time.Millisecond * 50)
+	if alive, _ := r.aliveServers(); len(alive) != 0 {
+		t.Fatalf("the stopped server shouldn't be registered again, got %v", alive)
+	}
+	if err := Deregister(ts.URL, "tcp@127.0.0.1:9999"); err != nil {
+		t.Fatal("deregistering a server twice should succeed:", err)
+	}
+}
+
+func TestHeartbeatHandle_StopUnresponsive(t *testing.T) {
+	block := make(chan struct{})
+	var heartbeats int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		// the registry accepts the first heartbeat, then never responds to heartbeats
+		if req.Method == "POST" && atomic.AddInt32(&heartbeats, 1) > 1 {
+			select {
+			case <-block:
+			case <-req.Context().Done():
+			}
+		}
+	}))
+	defer ts.Close()
+	defer close(block)
+	h := Heartbeat(ts.URL, "tcp@127.0.0.1:9999", time.Millisecond*10)
+	for atomic.LoadInt32(&heartbeats) < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	stopped := make(chan error, 1)
+	go func() { stopped <- h.Stop() }()
+	select {
+	case err := <-stopped:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Stop should abort the heartbeat in flight")
+	}
+}
+
+func TestGeeRegistry_Store(t *testing.T) {
+	dir := t.TempDir()
+	s, err := OpenStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := New(time.Minute, WithStore(s))
+	r.putServer(&ServerItem{Addr: "tcp@127.0.0.1:9997", Tags: []string{"canary"}}, false)
+	r.putServer(&ServerItem{Addr: "tcp@127.0.0.1:9998"}, false)
+	r.putServer(&ServerItem{Addr: "tcp@127.0.0.1:9999"}, false)
+	r.removeServer(newServerKey("", "tcp@127.0.0.1:9999"))
+	_ = s.Close()
+
+	s, err = OpenStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = s.Close() }()
+	r = New(time.Minute, WithStore(s))
+	if len(r.servers) != 2 || r.servers[newServerKey("", "tcp@127.0.0.1:9997")] == nil || r.servers[newServerKey("", "tcp@127.0.0.1:9998")] == nil {
+		t.Fatalf("expect 2 servers restored, got %v", r.servers)
+	}
+	if alive, _ := r.aliveServers(); len(alive) != 0 {
+		t.Fatalf("restored servers should be stale until their heartbeat, got %v", alive)
+	}
+	r.putServer(&ServerItem{Addr: "tcp@127.0.0.1:9998"}, false)
+	alive, _ := r.aliveServers()
+	if len(alive) != 1 || alive[0].Addr != "tcp@127.0.0.1:9998" {
+		t.Fatalf("expect the confirmed server alive, got %v", alive)
+	}
+	if tags := r.servers[newServerKey("", "tcp@127.0.0.1:9997")].Tags; len(tags) != 1 || tags[0] != "canary" {
+		t.Fatalf("expect metadata restored, got %v", tags)
+	}
+}
+
+func TestGeeRegistry_StoreConcurrent(t *testing.T) {
+	dir := t.TempDir()
+	s, err := OpenStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := New(time.Minute, WithStore(s))
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			r.putServer(&ServerItem{Addr: "tcp@127.0.0.1:" + strconv.Itoa(9000+i)}, false)
+		}(i)
+	}
+	wg.Wait()
+	if s.synced != s.written || s.written != 50 {
+		t.Fatalf("expect all 50 records synced, written %d synced %d", s.written, s.synced)
+	}
+	_ = s.Close()
+
+	s, err = OpenStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = s.Close() }()
+	if n := len(s.take()); n != 50 {
+		t.Fatalf("expect 50 servers restored, got %d", n)
+	}
+}
+
+func TestGeeRegistry_StoreExpire(t *testing.T) {
+	s, err := OpenStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = s.Close() }()
+	s.restored = []*ServerItem{{Addr: "tcp@127.0.0.1:9999"}}
+	r := New(time.Millisecond*10, WithStore(s))
+	time.Sleep(time.Millisecond * 20)
+	r.aliveServers()
+	if len(r.servers) != 0 {
+		t.Fatal("a restored server without heartbeat should expire")
+	}
+}
+
+func TestGeeRegistry_Sync(t *testing.T) {
+	r1, r2 := New(time.Minute), New(time.Minute)
+	ts := httptest.NewServer(r2)
+	defer ts.Close()
+
+	r1.putServer(&ServerItem{Addr: "tcp@127.0.0.1:9998", Tags: []string{"canary"}}, false)
+	r2.putServer(&ServerItem{Addr: "tcp@127.0.0.1:9999"}, false)
+	if err := r1.syncWith(ts.URL); err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range []*GeeRegistry{r1, r2} {
+		if alive, _ := r.aliveServers(); len(alive) != 2 {
+			t.Fatalf("expect both servers replicated, got %v", alive)
+		}
+	}
+	if tags := r2.servers[newServerKey("", "tcp@127.0.0.1:9998")].Tags; len(tags) != 1 {
+		t.Fatalf("expect metadata replicated, got %v", tags)
+	}
+
+	time.Sleep(time.Millisecond)
+	r2.removeServer(newServerKey("", "tcp@127.0.0.1:9998"))
+	if err := r1.syncWith(ts.URL); err != nil {
+		t.Fatal(err)
+	}
+	if alive, _ := r1.aliveServers(); len(alive) != 1 || alive[0].Addr != "tcp@127.0.0.1:9999" {
+		t.Fatalf("expect the deregistration replicated, got %v", alive)
+	}
+	if err := r1.syncWith(ts.URL); err != nil {
+		t.Fatal(err)
+	}
+	if alive, _ := r2.aliveServers(); len(alive) != 1 {
+		t.Fatalf("a deregistered server shouldn't come back from a peer, got %v", alive)
+	}
+}
+
+func TestGeeRegistry_HealthProbe(t *testing.T) {
+	good, bad := "tcp@127.0.0.1:9998", "tcp@127.0.0.1:9999"
+	var mu sync.Mutex
+	wedged := map[string]bool{bad: true}
+	probe := func(addr string, timeout time.Duration) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if wedged[addr] {
+			return errors.New("timeout")
+		}
+		return nil
+	}
+	r := New(time.Minute, WithHealthProbe(probe, time.Hour, time.Millisecond*100, 2))
+	defer func() { _ = r.Close() }()
+	r.putServer(&ServerItem{Addr: good}, false)
+	r.putServer(&ServerItem{Addr: bad}, false)
+	r.probeAll()
+	if alive, _ := r.aliveServers(); len(alive) != 2 {
+		t.Fatalf("a server should stay alive until failures probes fail, got %v", alive)
+	}
+	r.probeAll()
+	if alive, _ := r.aliveServers(); len(alive) != 1 || alive[0].Addr != good {
+		t.Fatalf("expect the wedged server excluded, got %v", alive)
+	}
+	r.putServer(&ServerItem{Addr: bad}, false)
+	if alive, _ := r.aliveServers(); len(alive) != 1 {
+		t.Fatalf("heartbeats shouldn't make an unhealthy server alive, got %v", alive)
+	}
+	mu.Lock()
+	wedged[bad] = false
+	mu.Unlock()
+	r.probeAll()
+	if alive, _ := r.aliveServers(); len(alive) != 2 {
+		t.Fatalf("a successful probe should make the server alive again, got %v", alive)
+	}
+}
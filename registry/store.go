@@ -0,0 +1,245 @@
+package registry
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// Store keeps the registrations of a GeeRegistry on disk, so that a restarted registry
+// knows its servers before their next heartbeat. It is a snapshot plus an append-only log
+// of the changes after the snapshot, heartbeats only refresh the time and are not logged.
+// The log is compacted into a new snapshot when it is opened and every compactThreshold records.
+// Records are written under the lock of the registry and flushed to disk by sync after it is released,
+// so that disk latency doesn't block other requests and concurrent changes share one fsync.
+type Store struct {
+	dir      string
+	mu       sync.Mutex // protect following
+	wal      *os.File
+	records  int           // records in the log since the last snapshot
+	written  uint64        // records ever appended
+	restored []*ServerItem // servers loaded by OpenStore, taken by New
+
+	syncMu sync.Mutex // serializes sync, protect following
+	synced uint64     // records known to be on disk
+}
+
+const (
+	snapshotFile     = "snapshot.json"
+	walFile          = "wal.log"
+	compactThreshold = 1000
+)
+
+// storeRecord is a line of the log
+type storeRecord struct {
+	Op        string      // "put" or "del"
+	Item      *ServerItem `json:",omitempty"` // for put
+	Addr      string      `json:",omitempty"` // for del
+	Namespace string      `json:",omitempty"` // for del
+}
+
+// OpenStore loads the registrations in dir, dir is created if it doesn't exist
+func OpenStore(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	servers := make(map[serverKey]*ServerItem)
+	if err := loadSnapshot(filepath.Join(dir, snapshotFile), servers); err != nil {
+		return nil, err
+	}
+	if err := replayWAL(filepath.Join(dir, walFile), servers); err != nil {
+		return nil, err
+	}
+	s := &Store{dir: dir}
+	for _, item := range servers {
+		s.restored = append(s.restored, item)
+	}
+	sort.Slice(s.restored, func(i, j int) bool {
+		if s.restored[i].Addr != s.restored[j].Addr {
+			return s.restored[i].Addr < s.restored[j].Addr
+		}
+		return s.restored[i].namespace() < s.restored[j].namespace()
+	})
+	if err := s.compact(s.restored); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func loadSnapshot(path string, servers map[serverKey]*ServerItem) error {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var items []*ServerItem
+	if err := json.Unmarshal(data, &items); err != nil {
+		return err
+	}
+	for _, item := range items {
+		servers[item.key()] = item
+	}
+	return nil
+}
+
+func replayWAL(path string, servers map[serverKey]*ServerItem) error {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var rec storeRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			// the last record may be cut off by a crash, the records before it are still good
+			log.Println("rpc registry: skip broken record in", path, err)
+			break
+		}
+		switch rec.Op {
+		case "put":
+			if rec.Item != nil {
+				servers[rec.Item.key()] = rec.Item
+			}
+		case "del":
+			delete(servers, newServerKey(rec.Namespace, rec.Addr))
+		}
+	}
+	return scanner.Err()
+}
+
+// take returns the servers loaded by OpenStore, only once
+func (s *Store) take() []*ServerItem {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	items := s.restored
+	s.restored = nil
+	return items
+}
+
+// append writes rec to the log, it reports whether the log should be compacted
+func (s *Store) append(rec storeRecord) (bool, error) {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return false, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.wal == nil {
+		return false, os.ErrClosed
+	}
+	if _, err := s.wal.Write(append(data, '\n')); err != nil {
+		return false, err
+	}
+	s.records++
+	s.written++
+	return s.records >= compactThreshold, nil
+}
+
+// sync flushes the records appended so far to disk. Callers waiting for a sync in progress
+// find their records flushed by the next one, or by the one in progress if they were in time.
+func (s *Store) sync() error {
+	s.syncMu.Lock()
+	defer s.syncMu.Unlock()
+	s.mu.Lock()
+	wal, written := s.wal, s.written
+	s.mu.Unlock()
+	if wal == nil || written <= s.synced {
+		return nil
+	}
+	// a log closed by compact was written into the snapshot, which is synced
+	if err := wal.Sync(); err != nil && !errors.Is(err, os.ErrClosed) {
+		return err
+	}
+	s.synced = written
+	return nil
+}
+
+// compact writes items as the new snapshot and empties the log
+func (s *Store) compact(items []*ServerItem) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if items == nil {
+		items = []*ServerItem{}
+	}
+	data, err := json.Marshal(items)
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(s.dir, snapshotFile+".tmp")
+	if err := writeFileSync(tmp, data); err != nil {
+		return err
+	}
+	// the snapshot is replaced atomically, a crash before truncating the log only replays it twice
+	if err := os.Rename(tmp, filepath.Join(s.dir, snapshotFile)); err != nil {
+		return err
+	}
+	// the rename is only durable once the directory is synced, the log mustn't be truncated before
+	if err := syncDir(s.dir); err != nil {
+		return err
+	}
+	if s.wal != nil {
+		_ = s.wal.Close()
+	}
+	s.wal, err = os.OpenFile(filepath.Join(s.dir, walFile), os.O_CREATE|os.O_WRONLY|os.O_TRUNC|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	s.records = 0
+	return nil
+}
+
+func writeFileSync(path string, data []byte) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// syncDir flushes the entries of dir to disk
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	if err := d.Sync(); err != nil {
+		_ = d.Close()
+		return err
+	}
+	return d.Close()
+}
+
+// Close closes the log, the registry using the store stops persisting changes
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.wal == nil {
+		return nil
+	}
+	err := s.wal.Sync()
+	if e := s.wal.Close(); err == nil {
+		err = e
+	}
+	s.wal = nil
+	return err
+}
@@ -0,0 +1,65 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+)
+
+type metadataKey struct{}
+
+// MetadataFromContext 返回请求 Header 中客户端发送的元数据，在 RegisterFunc 注册的函数
+// 和第一个参数是 context.Context 的方法中使用
+func MetadataFromContext(ctx context.Context) map[string]string {
+	md, _ := ctx.Value(metadataKey{}).(map[string]string)
+	return md
+}
+
+// RegisterFunc registers fn as the method serviceMethod ("Service.Method") of the server,
+// without a receiver type. Functions of the same service are merged into one service,
+// a service registered by Register can't get more methods from RegisterFunc.
+// The ctx of fn carries the metadata of the request, see MetadataFromContext.
+func RegisterFunc[Req, Resp any](server *Server, serviceMethod string, fn func(ctx context.Context, req Req) (Resp, error)) error {
+	dot := strings.LastIndex(serviceMethod, ".")
+	if dot <= 0 || dot == len(serviceMethod)-1 {
+		return errors.New("rpc server: service/method ill-formed: " + serviceMethod)
+	}
+	name, methodName := serviceMethod[:dot], serviceMethod[dot+1:]
+	m := &methodType{
+		ArgType:   reflect.TypeOf((*Req)(nil)).Elem(),
+		ReplyType: reflect.TypeOf((*Resp)(nil)),
+		fn: func(ctx context.Context, argv, replyv reflect.Value) error {
+			resp, err := fn(ctx, argv.Interface().(Req))
+			if err != nil {
+				return err
+			}
+			*replyv.Interface().(*Resp) = resp
+			return nil
+		},
+	}
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	s := &service{name: name, method: map[string]*methodType{methodName: m}, calls: newCallTracker()}
+	if svci, ok := server.serviceMap.Load(name); ok {
+		old := svci.(*service)
+		if old.typ != nil {
+			return errors.New("rpc: service already defined by a type: " + name)
+		}
+		if old.method[methodName] != nil {
+			return errors.New("rpc: method already defined: " + serviceMethod)
+		}
+		// copy on write, requests being served read the method map without lock
+		for k, v := range old.method {
+			s.method[k] = v
+		}
+		s.calls = old.calls
+	}
+	server.serviceMap.Store(name, s)
+	server.logf("rpc server: register %s\n", serviceMethod)
+	if server.health != nil {
+		server.health.servicesChanged()
+	}
+	return nil
+}
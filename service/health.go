@@ -0,0 +1,207 @@
+package service
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ServingStatus 服务的健康状态
+type ServingStatus int
+
+const (
+	StatusUnknown    ServingStatus = iota // 服务不存在
+	StatusServing                         // 正常提供服务
+	StatusNotServing                      // 暂时无法提供服务
+	StatusDraining                        // 正在关闭，不再接收新的调用
+)
+
+func (s ServingStatus) String() string {
+	switch s {
+	case StatusServing:
+		return "SERVING"
+	case StatusNotServing:
+		return "NOT_SERVING"
+	case StatusDraining:
+		return "DRAINING"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// HealthCheckRequest Service 为空表示询问整个 Server 的状态
+type HealthCheckRequest struct {
+	Service string
+}
+
+type HealthCheckResponse struct {
+	Status ServingStatus
+}
+
+// HealthWatchRequest Watch 在状态与 Status 不同或者等待 Wait 之后返回
+type HealthWatchRequest struct {
+	Service string
+	Status  ServingStatus // 调用方已知的状态
+	Wait    time.Duration // 0 表示 defaultHealthWait，最长 maxHealthWait
+}
+
+const (
+	defaultHealthWait = time.Second * 30
+	maxHealthWait     = time.Minute
+	maxHealthWatchers = 100 // 超过后 Watch 立即返回当前状态，不再占用处理协程
+)
+
+// Health 可选的内置健康检查服务，通过 EnableHealth 注册为 "Health" 服务，通过 Server.Health 获取。
+// 应用通过 SetServingStatus 设置状态，关闭时调用 Shutdown 进入 draining 状态，让注册中心和客户端尽快摘除该节点。
+// 已注册但没有单独设置状态的服务与整个 Server 的状态相同。没有 EnableHealth 时也可以设置状态，只是不对外提供。
+type Health struct {
+	server      *Server
+	maxWatchers int
+
+	mu       sync.Mutex // protect following
+	status   map[string]ServingStatus
+	draining bool          // Shutdown 之后所有服务都是 StatusDraining
+	notify   chan struct{} // 状态变化时关闭并替换，唤醒 Watch
+	watchers int           // 正在等待的 Watch 调用数
+}
+
+func newHealth(server *Server) *Health {
+	return &Health{
+		server:      server,
+		maxWatchers: maxHealthWatchers,
+		status:      map[string]ServingStatus{"": StatusServing},
+		notify:      make(chan struct{}),
+	}
+}
+
+// Health returns the health service of the server
+func (server *Server) Health() *Health {
+	return server.health
+}
+
+// EnableHealth registers the Health service on the server
+func (server *Server) EnableHealth() error {
+	// Health 的管理方法不是 RPC 方法，不参与严格模式的检查，也不输出日志
+	s, _ := newService(server.health, "")
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if _, dup := server.serviceMap.LoadOrStore(s.name, s); dup {
+		return errors.New("rpc: service already defined: " + s.name)
+	}
+	return nil
+}
+
+// EnableHealth registers the Health service on the DefaultServer
+func EnableHealth() error { return DefaultServer.EnableHealth() }
+
+// SetServingStatus sets the status of service, an empty service means the server as a whole.
+// It has no effect after Shutdown until Resume.
+func (h *Health) SetServingStatus(service string, status ServingStatus) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.status[service] == status {
+		return
+	}
+	h.status[service] = status
+	if !h.draining {
+		h.changed()
+	}
+}
+
+// Shutdown marks the server and all its services as draining
+func (h *Health) Shutdown() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.draining {
+		h.draining = true
+		h.changed()
+	}
+}
+
+// Resume undoes Shutdown, statuses set before and after Shutdown come back
+func (h *Health) Resume() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.draining {
+		h.draining = false
+		h.changed()
+	}
+}
+
+// changed wakes up watchers, h.mu must be held
+func (h *Health) changed() {
+	close(h.notify)
+	h.notify = make(chan struct{})
+}
+
+// servicesChanged wakes up watchers when services are registered or unregistered
+func (h *Health) servicesChanged() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.changed()
+}
+
+// statusOf must be called with h.mu held
+func (h *Health) statusOf(service string) ServingStatus {
+	if service != "" {
+		if _, ok := h.server.serviceMap.Load(service); !ok {
+			return StatusUnknown
+		}
+	}
+	if h.draining {
+		return StatusDraining
+	}
+	if status, ok := h.status[service]; ok {
+		return status
+	}
+	return h.status[""]
+}
+
+// Check returns the status of the service
+func (h *Health) Check(args HealthCheckRequest, reply *HealthCheckResponse) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	reply.Status = h.statusOf(args.Service)
+	return nil
+}
+
+// Watch is a long poll, it returns the status of the service once it differs from args.Status or args.Wait has passed.
+// With too many calls waiting already, it returns the current status at once.
+func (h *Health) Watch(args HealthWatchRequest, reply *HealthCheckResponse) error {
+	h.mu.Lock()
+	if h.watchers >= h.maxWatchers {
+		reply.Status = h.statusOf(args.Service)
+		h.mu.Unlock()
+		return nil
+	}
+	h.watchers++
+	h.mu.Unlock()
+	defer func() {
+		h.mu.Lock()
+		h.watchers--
+		h.mu.Unlock()
+	}()
+	wait := args.Wait
+	if wait <= 0 {
+		wait = defaultHealthWait
+	}
+	if wait > maxHealthWait {
+		wait = maxHealthWait
+	}
+	t := time.NewTimer(wait)
+	defer t.Stop()
+	for {
+		h.mu.Lock()
+		reply.Status = h.statusOf(args.Service)
+		notify := h.notify
+		h.mu.Unlock()
+		if reply.Status != args.Status {
+			return nil
+		}
+		select {
+		case <-notify:
+		case <-t.C:
+			return nil
+		}
+	}
+}
@@ -1,15 +1,19 @@
 package service
 
 import (
+	"context"
 	"reflect"
 	"sync/atomic"
 )
 
 type methodType struct {
-	method    reflect.Method
-	ArgType   reflect.Type
-	ReplyType reflect.Type
-	numCalls  uint64 // 统计方法调用次数
+	method      reflect.Method
+	ArgType     reflect.Type
+	ReplyType   reflect.Type
+	numCalls    uint64 // 统计方法调用次数
+	withContext bool   // 方法的第一个参数是 context.Context，调用时传入请求的 ctx
+	// fn 不为空时是 RegisterFunc 注册的函数，代替 method 被调用
+	fn func(ctx context.Context, argv, replyv reflect.Value) error
 }
 
 func (m *methodType) NumCalls() uint64 {
@@ -0,0 +1,146 @@
+package service
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Reflection 可选的内置服务，通过 EnableReflection 注册为 "Reflection" 服务，
+// 列出 Server 上注册的服务和方法，并描述参数和返回值的结构，方便工具动态地发现和调用方法。
+type Reflection struct {
+	server *Server
+}
+
+// ListServicesRequest Prefix 不为空时只列出名称以其开头的服务
+type ListServicesRequest struct {
+	Prefix string
+}
+
+type ListServicesResponse struct {
+	Services []ServiceInfo
+}
+
+// ServiceInfo 服务名及其方法名
+type ServiceInfo struct {
+	Name    string
+	Methods []string
+}
+
+// DescribeRequest Method 为空表示描述服务的所有方法
+type DescribeRequest struct {
+	Service string
+	Method  string
+}
+
+// ServiceDescriptor 描述一个服务
+type ServiceDescriptor struct {
+	Name    string
+	Methods []MethodDescriptor
+}
+
+// MethodDescriptor 描述一个方法的参数和返回值
+type MethodDescriptor struct {
+	Name      string
+	ArgType   *TypeDescriptor
+	ReplyType *TypeDescriptor
+}
+
+// TypeDescriptor 描述一个类型，嵌套的类型在 Fields、Elem 和 Key 中。
+// 递归的类型第二次出现时只有 Name 和 Kind。
+type TypeDescriptor struct {
+	Name   string            // 类型名，例如 "main.Args"、"*main.Args"、"[]int"
+	Kind   string            // reflect.Kind，例如 "struct"、"ptr"、"slice"、"int"
+	Fields []FieldDescriptor // Kind 为 struct 时的导出字段
+	Elem   *TypeDescriptor   // Kind 为 ptr、slice、array、map 时的元素类型
+	Key    *TypeDescriptor   // Kind 为 map 时的 key 类型
+	Len    int               // Kind 为 array 时的长度
+}
+
+// FieldDescriptor 描述结构体的一个导出字段
+type FieldDescriptor struct {
+	Name string
+	Type *TypeDescriptor
+}
+
+// EnableReflection registers the Reflection service on the server
+func (server *Server) EnableReflection() error {
+	return server.Register(&Reflection{server: server})
+}
+
+// EnableReflection registers the Reflection service on the DefaultServer
+func EnableReflection() error { return DefaultServer.EnableReflection() }
+
+// ListServices lists the services and their methods sorted by name
+func (r *Reflection) ListServices(args ListServicesRequest, reply *ListServicesResponse) error {
+	r.server.serviceMap.Range(func(key, value interface{}) bool {
+		s := value.(*service)
+		if !strings.HasPrefix(s.name, args.Prefix) {
+			return true
+		}
+		info := ServiceInfo{Name: s.name}
+		for name := range s.method {
+			info.Methods = append(info.Methods, name)
+		}
+		sort.Strings(info.Methods)
+		reply.Services = append(reply.Services, info)
+		return true
+	})
+	sort.Slice(reply.Services, func(i, j int) bool { return reply.Services[i].Name < reply.Services[j].Name })
+	return nil
+}
+
+// Describe describes the methods of a service
+func (r *Reflection) Describe(args DescribeRequest, reply *ServiceDescriptor) error {
+	svci, ok := r.server.serviceMap.Load(args.Service)
+	if !ok {
+		return errors.New("rpc server: can't find service " + args.Service)
+	}
+	s := svci.(*service)
+	reply.Name = s.name
+	for name, m := range s.method {
+		if args.Method != "" && name != args.Method {
+			continue
+		}
+		reply.Methods = append(reply.Methods, MethodDescriptor{
+			Name:      name,
+			ArgType:   describeType(m.ArgType, map[reflect.Type]bool{}),
+			ReplyType: describeType(m.ReplyType, map[reflect.Type]bool{}),
+		})
+	}
+	if args.Method != "" && len(reply.Methods) == 0 {
+		return errors.New("rpc server: can't find method " + args.Method)
+	}
+	sort.Slice(reply.Methods, func(i, j int) bool { return reply.Methods[i].Name < reply.Methods[j].Name })
+	return nil
+}
+
+// describeType 递归地描述 t，visiting 记录正在描述的结构体，避免递归类型无限展开
+func describeType(t reflect.Type, visiting map[reflect.Type]bool) *TypeDescriptor {
+	d := &TypeDescriptor{Name: t.String(), Kind: t.Kind().String()}
+	switch t.Kind() {
+	case reflect.Struct:
+		if visiting[t] {
+			return d
+		}
+		visiting[t] = true
+		defer delete(visiting, t)
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.PkgPath != "" {
+				continue // 未导出的字段不会被编码
+			}
+			d.Fields = append(d.Fields, FieldDescriptor{Name: f.Name, Type: describeType(f.Type, visiting)})
+		}
+	case reflect.Array:
+		d.Len = t.Len()
+		d.Elem = describeType(t.Elem(), visiting)
+	case reflect.Ptr, reflect.Slice:
+		d.Elem = describeType(t.Elem(), visiting)
+	case reflect.Map:
+		d.Key = describeType(t.Key(), visiting)
+		d.Elem = describeType(t.Elem(), visiting)
+	}
+	return d
+}
@@ -1,56 +1,197 @@
 package service
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"go/ast"
-	"log"
 	"reflect"
+	"sort"
+	"strings"
 	"sync/atomic"
 )
 
 // Register publishes in the server the set of methods of the
+// receiver value, the service is named after the type of the receiver.
+// A method is func (t *T) M(args A, reply *R) error, or func (t *T) M(ctx context.Context, args A, reply *R) error
+// whose ctx carries the metadata of the request, see MetadataFromContext.
 func (server *Server) Register(rcvr interface{}) error {
-	s := newService(rcvr)
+	return server.RegisterName("", rcvr)
+}
+
+// RegisterName is like Register but uses the provided name for the service instead of the type name,
+// so that several instances of a type can be registered. An empty name means the type name.
+// Exported methods that can't be RPC methods are skipped and logged, see SkippedMethods,
+// with WithStrictRegistration they make RegisterName fail with a *RegistrationError.
+func (server *Server) RegisterName(name string, rcvr interface{}) error {
+	s, err := newService(rcvr, name)
+	if err != nil {
+		return err
+	}
+	if server.strict && len(s.skipped) > 0 {
+		return &RegistrationError{Service: s.name, Skipped: s.skipped}
+	}
+	server.mu.Lock()
+	defer server.mu.Unlock()
 	if _, dup := server.serviceMap.LoadOrStore(s.name, s); dup {
 		return errors.New("rpc: service already defined: " + s.name)
 	}
+	for _, m := range s.skipped {
+		server.logf("rpc server: skip %s.%s: %s\n", s.name, m.Name, m.Reason)
+	}
+	names := make([]string, 0, len(s.method))
+	for methodName := range s.method {
+		names = append(names, methodName)
+	}
+	sort.Strings(names)
+	for _, methodName := range names {
+		server.logf("rpc server: register %s.%s\n", s.name, methodName)
+	}
+	if server.health != nil {
+		server.health.servicesChanged()
+	}
 	return nil
 }
 
+// SkippedMethod 注册时被跳过的导出方法及原因
+type SkippedMethod struct {
+	Name   string
+	Reason string
+}
+
+// RegistrationError 严格模式下，服务有导出方法无法注册时返回的错误
+type RegistrationError struct {
+	Service string
+	Skipped []SkippedMethod
+}
+
+func (e *RegistrationError) Error() string {
+	reasons := make([]string, 0, len(e.Skipped))
+	for _, m := range e.Skipped {
+		reasons = append(reasons, m.Name+": "+m.Reason)
+	}
+	return fmt.Sprintf("rpc: service %s has methods that can't be registered: %s", e.Service, strings.Join(reasons, "; "))
+}
+
+// SkippedMethods returns the exported methods of the service skipped at registration
+func (server *Server) SkippedMethods(name string) []SkippedMethod {
+	svci, ok := server.serviceMap.Load(name)
+	if !ok {
+		return nil
+	}
+	return append([]SkippedMethod(nil), svci.(*service).skipped...)
+}
+
+// Unregister removes the service from the server, new calls to it fail with "can't find service",
+// and Unregister returns once the calls being handled complete. A method of the service calling
+// Unregister waits for itself and never returns, it should use UnregisterContext or call Unregister
+// in a new goroutine. Long polls such as Health.Watch delay it until they return.
+func (server *Server) Unregister(name string) error {
+	return server.UnregisterContext(context.Background(), name)
+}
+
+// UnregisterContext is Unregister, but stops waiting for the calls being handled when ctx is done
+// and returns ctx.Err(). The service is removed either way, the calls complete in the background.
+func (server *Server) UnregisterContext(ctx context.Context, name string) error {
+	server.mu.Lock()
+	svci, ok := server.serviceMap.LoadAndDelete(name)
+	if ok && server.health != nil {
+		server.health.servicesChanged()
+	}
+	server.mu.Unlock()
+	if !ok {
+		return errors.New("rpc: service not defined: " + name)
+	}
+	return svci.(*service).calls.close(ctx)
+}
+
 // Register publishes the receiver's methods in the DefaultServer.
 func Register(rcvr interface{}) error { return DefaultServer.Register(rcvr) }
 
+// RegisterName is like Register but uses the provided name for the service in the DefaultServer.
+func RegisterName(name string, rcvr interface{}) error { return DefaultServer.RegisterName(name, rcvr) }
+
+// registerMethods 注册符合条件的方法，其余的导出方法及原因记录在 s.skipped 中
 func (s *service) registerMethods() {
 	s.method = make(map[string]*methodType)
+	s.skipped = nil
 	for i := 0; i < s.typ.NumMethod(); i++ {
 		method := s.typ.Method(i)
-		mType := method.Type
-		// 过滤出符合条件的方法: 反射时为 3 个，第 0 个是自身
-		if mType.NumIn() != 3 || mType.NumOut() != 1 {
-			continue
-		}
-		// 返回值有且只有 1 个，类型为 error
-		if mType.Out(0) != reflect.TypeOf((*error)(nil)).Elem() {
+		if reason := checkMethod(method.Type); reason != "" {
+			s.skipped = append(s.skipped, SkippedMethod{Name: method.Name, Reason: reason})
 			continue
 		}
-		argType, replyType := mType.In(1), mType.In(2)
-		if !isExportedOrBuiltinType(argType) || !isExportedOrBuiltinType(replyType) {
-			continue
+		in := 1
+		if hasContext(method.Type) {
+			in = 2
 		}
 		s.method[method.Name] = &methodType{
-			method:    method,
-			ArgType:   argType,
-			ReplyType: replyType,
+			method:      method,
+			ArgType:     method.Type.In(in),
+			ReplyType:   method.Type.In(in + 1),
+			withContext: in == 2,
 		}
-		log.Printf("rpc server: register %s.%s\n", s.name, method.Name)
 	}
 }
 
+var typeOfContext = reflect.TypeOf((*context.Context)(nil)).Elem()
+
+// hasContext 方法的第 1 个参数是 context.Context，之后是 args 和 reply
+func hasContext(mType reflect.Type) bool {
+	return mType.NumIn() == 4 && mType.In(1) == typeOfContext
+}
+
+// checkMethod 返回方法不能注册的原因，可以注册时返回空字符串
+func checkMethod(mType reflect.Type) string {
+	// 过滤出符合条件的方法: 反射时为 3 个，第 0 个是自身，带 context.Context 时为 4 个
+	in := 1
+	if hasContext(mType) {
+		in = 2
+	}
+	if mType.NumIn() != in+2 {
+		return fmt.Sprintf("has %d parameters, want 2 (args, *reply)", mType.NumIn()-1)
+	}
+	// 返回值有且只有 1 个，类型为 error
+	if mType.NumOut() != 1 {
+		return fmt.Sprintf("has %d results, want 1 of type error", mType.NumOut())
+	}
+	if mType.Out(0) != reflect.TypeOf((*error)(nil)).Elem() {
+		return fmt.Sprintf("returns %s, want error", mType.Out(0))
+	}
+	argType, replyType := mType.In(in), mType.In(in+1)
+	if !isExportedOrBuiltinType(argType) {
+		return fmt.Sprintf("args type %s is not exported", argType)
+	}
+	if replyType.Kind() != reflect.Ptr {
+		return fmt.Sprintf("reply type %s is not a pointer", replyType)
+	}
+	if !isExportedOrBuiltinType(replyType) {
+		return fmt.Sprintf("reply type %s is not exported", replyType)
+	}
+	return ""
+}
+
 // 实现 call 方法，即能够通过反射值调用方法
 func (s *service) call(m *methodType, argv, replyv reflect.Value) error {
+	return s.callContext(context.Background(), m, argv, replyv)
+}
+
+// callContext ctx 传给 RegisterFunc 注册的函数和第一个参数是 context.Context 的方法
+func (s *service) callContext(ctx context.Context, m *methodType, argv, replyv reflect.Value) error {
+	if !s.calls.acquire() {
+		return errors.New("rpc server: can't find service " + s.name)
+	}
+	defer s.calls.release()
 	atomic.AddUint64(&m.numCalls, 1)
+	if m.fn != nil {
+		return m.fn(ctx, argv, replyv)
+	}
 	f := m.method.Func
-	returnValues := f.Call([]reflect.Value{s.rcvr, argv, replyv})
+	in := []reflect.Value{s.rcvr, argv, replyv}
+	if m.withContext {
+		in = []reflect.Value{s.rcvr, reflect.ValueOf(&ctx).Elem(), argv, replyv}
+	}
+	returnValues := f.Call(in)
 	if errInter := returnValues[0].Interface(); errInter != nil {
 		return errInter.(error)
 	}
@@ -1,6 +1,8 @@
 package service
 
 import (
+	"bufio"
+	"context"
 	"encoding/json"
 	"geeRPC/codec/codec"
 	"io"
@@ -34,12 +36,51 @@ var DefaultOption = &Option{
 
 // Server represents an RPC Server.
 type Server struct {
+	mu         sync.Mutex // serialize registrations
 	serviceMap sync.Map
+	health     *Health
+	logger     Logger // nil means registrations are not logged
+	strict     bool   // registration fails if any exported method is skipped
 }
 
-// NewServer returns a new Server.
-func NewServer() *Server {
-	return &Server{}
+// Logger 输出注册日志的接口，*log.Logger 实现了它
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// ServerOption configures a Server
+type ServerOption func(server *Server)
+
+// WithLogger logs registrations and skipped methods through l instead of the standard logger,
+// nil disables the logs.
+func WithLogger(l Logger) ServerOption {
+	return func(server *Server) {
+		server.logger = l
+	}
+}
+
+// WithStrictRegistration makes Register fail with a *RegistrationError
+// if any exported method of the receiver can't be registered.
+func WithStrictRegistration() ServerOption {
+	return func(server *Server) {
+		server.strict = true
+	}
+}
+
+// NewServer returns a new Server
+func NewServer(opts ...ServerOption) *Server {
+	server := &Server{logger: log.Default()}
+	for _, opt := range opts {
+		opt(server)
+	}
+	server.health = newHealth(server)
+	return server
+}
+
+func (server *Server) logf(format string, v ...interface{}) {
+	if server.logger != nil {
+		server.logger.Printf(format, v...)
+	}
 }
 
 // DefaultServer Accept accepts connections on the listener and serves requests
@@ -59,7 +100,8 @@ func (server *Server) ServeConn(conn io.ReadWriteCloser) {
 	defer func() { _ = conn.Close() }()
 	var opt Option
 	// json.NewDecoder 反序列化得到 Option 实例
-	if err := json.NewDecoder(conn).Decode(&opt); err != nil {
+	dec := json.NewDecoder(conn)
+	if err := dec.Decode(&opt); err != nil {
 		log.Println("rpc server: options error: ", err)
 		return
 	}
@@ -73,9 +115,23 @@ func (server *Server) ServeConn(conn io.ReadWriteCloser) {
 		log.Printf("rpc server: invalid codec type %s", opt.CodecType)
 		return
 	}
-	server.serveCodec(f(conn))
+	// Decoder 可能已经预读了 Option 之后的请求报文，需要把这部分数据交还给 Codec，
+	// 同时跳过 json.Encoder 写在 Option 之后的换行符
+	r := bufio.NewReader(io.MultiReader(dec.Buffered(), conn))
+	if b, err := r.Peek(1); err == nil && b[0] == '\n' {
+		_, _ = r.Discard(1)
+	}
+	server.serveCodec(f(&bufferedConn{Reader: r, ReadWriteCloser: conn}))
+}
+
+// bufferedConn 从 Reader 读取数据，写入和关闭仍然使用原始连接
+type bufferedConn struct {
+	io.Reader
+	io.ReadWriteCloser
 }
 
+func (c *bufferedConn) Read(p []byte) (int, error) { return c.Reader.Read(p) }
+
 var invalidRequest = struct{}{}
 
 // Accept accepts connections on the listener and serves requests
@@ -166,6 +222,7 @@ func (server *Server) readRequest(cc codec.Codec) (*request, error) {
 func (server *Server) sendResponse(cc codec.Codec, header *codec.Header, body interface{}, sending *sync.Mutex) {
 	sending.Lock()
 	defer sending.Unlock()
+	header.Metadata = nil // metadata is only sent with requests
 	if err := cc.Write(header, body); err != nil {
 		log.Println("rpc server: write response error:", err)
 	}
@@ -174,7 +231,8 @@ func (server *Server) sendResponse(cc codec.Codec, header *codec.Header, body in
 func (server *Server) handleRequest(cc codec.Codec, req *request, sending *sync.Mutex, wg *sync.WaitGroup) {
 	defer wg.Done()
 	// 通过 req.svc.call 完成方法调用，将 replyv 传递给 sendResponse 完成序列化即可。
-	err := req.svc.call(req.mtype, req.argv, req.replyv)
+	ctx := context.WithValue(context.Background(), metadataKey{}, req.header.Metadata)
+	err := req.svc.callContext(ctx, req.mtype, req.argv, req.replyv)
 	if err != nil {
 		req.header.Error = err.Error()
 		server.sendResponse(cc, req.header, invalidRequest, sending)
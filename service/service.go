@@ -1,31 +1,94 @@
 package service
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"go/ast"
-	"log"
 	"reflect"
 	"strings"
+	"sync"
 )
 
 type service struct {
-	name   string                 // 映射的结构体的名称
-	typ    reflect.Type           // 结构体的类型
-	rcvr   reflect.Value          // 结构体的实例本身 保留 rcvr 是因为在调用时需要 rcvr 作为第 0 个参数
-	method map[string]*methodType // map 类型，存储映射的结构体的所有符合条件的方法
+	name    string                 // 映射的结构体的名称
+	typ     reflect.Type           // 结构体的类型
+	rcvr    reflect.Value          // 结构体的实例本身 保留 rcvr 是因为在调用时需要 rcvr 作为第 0 个参数
+	method  map[string]*methodType // map 类型，存储映射的结构体的所有符合条件的方法
+	calls   *callTracker           // 正在处理的调用，Unregister 时等待它们完成
+	skipped []SkippedMethod        // 注册时被跳过的导出方法
 }
 
-// 入参是任意需要映射为服务的结构体实例
-func newService(rcvr interface{}) *service {
+// 入参是任意需要映射为服务的结构体实例，name 为空时使用结构体的名称，此时结构体必须是导出的
+func newService(rcvr interface{}, name string) (*service, error) {
 	s := new(service)
 	s.rcvr = reflect.ValueOf(rcvr)
-	s.name = reflect.Indirect(s.rcvr).Type().Name()
 	s.typ = reflect.TypeOf(rcvr)
-	if !ast.IsExported(s.name) {
-		log.Fatalf("rpc server: %s is not a valid service name", s.name)
+	if s.typ == nil {
+		return nil, errors.New("rpc server: service receiver is nil")
 	}
+	if s.rcvr.Kind() == reflect.Ptr && s.rcvr.IsNil() {
+		return nil, fmt.Errorf("rpc server: service receiver is a nil %s", s.typ)
+	}
+	s.name = name
+	if name == "" {
+		s.name = reflect.Indirect(s.rcvr).Type().Name()
+		if !ast.IsExported(s.name) {
+			return nil, fmt.Errorf("rpc server: %q is not a valid service name, use RegisterName", s.name)
+		}
+	}
+	s.calls = newCallTracker()
 	s.registerMethods()
-	return s
+	return s, nil
+}
+
+// callTracker 统计一个服务正在处理的调用，close 之后不再接收新的调用
+type callTracker struct {
+	mu      sync.Mutex
+	calls   int
+	closed  bool
+	drained chan struct{} // close 之后调用数降为 0 时关闭
+}
+
+func newCallTracker() *callTracker {
+	return &callTracker{drained: make(chan struct{})}
+}
+
+// acquire 开始一次调用，服务已经被注销时返回 false
+func (t *callTracker) acquire() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return false
+	}
+	t.calls++
+	return true
+}
+
+func (t *callTracker) release() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.calls--; t.calls == 0 && t.closed {
+		close(t.drained)
+	}
+}
+
+// close 拒绝新的调用，并等待正在处理的调用完成，ctx 结束时不再等待并返回 ctx.Err()
+func (t *callTracker) close(ctx context.Context) error {
+	t.mu.Lock()
+	if !t.closed {
+		t.closed = true
+		if t.calls == 0 {
+			close(t.drained)
+		}
+	}
+	t.mu.Unlock()
+	select {
+	case <-t.drained:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
 // 通过 ServiceMethod 从 serviceMap 中找到对应的 service
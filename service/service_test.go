@@ -1,9 +1,17 @@
 package service
 
 import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"geeRPC/codec/codec"
+	"net"
 	"reflect"
 	"testing"
+	"time"
 )
 
 type Foo int
@@ -29,7 +37,8 @@ func _assert(condition bool, msg string, v ...interface{}) {
 
 func TestNewService(t *testing.T) {
 	var foo Foo
-	s := newService(&foo)
+	s, err := newService(&foo, "")
+	_assert(err == nil, "failed to create service: %v", err)
 	_assert(len(s.method) == 1, "wrong service Method, expect 1, but got %d", len(s.method))
 	mType := s.method["Sum"]
 	_assert(mType != nil, "wrong Method, Sum shouldn't nil")
@@ -37,7 +46,7 @@ func TestNewService(t *testing.T) {
 
 func TestMethodType_Call(t *testing.T) {
 	var foo Foo
-	s := newService(&foo)
+	s, _ := newService(&foo, "")
 	mType := s.method["Sum"]
 
 	argv := mType.newArgv()
@@ -46,3 +55,292 @@ func TestMethodType_Call(t *testing.T) {
 	err := s.call(mType, argv, replyv)
 	_assert(err == nil && *replyv.Interface().(*int) == 4 && mType.NumCalls() == 1, "failed to call Foo.Sum")
 }
+
+type Tracer int
+
+// Trace 第一个参数是 context.Context，能读到请求的元数据
+func (t Tracer) Trace(ctx context.Context, args string, reply *string) error {
+	*reply = args + MetadataFromContext(ctx)["trace"]
+	return nil
+}
+
+func TestMethodType_CallContext(t *testing.T) {
+	var tracer Tracer
+	s, err := newService(&tracer, "")
+	_assert(err == nil && len(s.skipped) == 0, "failed to register a method taking a context: %v %v", err, s.skipped)
+	mType := s.method["Trace"]
+	_assert(mType != nil && mType.ArgType.Kind() == reflect.String, "wrong Method, Trace should take a string")
+
+	argv, replyv := mType.newArgv(), mType.newReplyv()
+	argv.SetString("id=")
+	ctx := context.WithValue(context.Background(), metadataKey{}, map[string]string{"trace": "abc"})
+	err = s.callContext(ctx, mType, argv, replyv)
+	_assert(err == nil && *replyv.Interface().(*string) == "id=abc", "expect the metadata passed to the method, got %q", *replyv.Interface().(*string))
+}
+
+// 客户端可能把 Option 和第一个请求放在同一次写入中发送，Option 之后的数据不能丢失
+func TestServer_OptionsAndRequestInOneWrite(t *testing.T) {
+	server := NewServer()
+	var foo Foo
+	_ = server.Register(&foo)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = l.Close() }()
+	go server.Accept(l)
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	var buf bytes.Buffer
+	_ = json.NewEncoder(&buf).Encode(DefaultOption)
+	enc := gob.NewEncoder(&buf)
+	_ = enc.Encode(&codec.Header{ServiceMethod: "Foo.Sum", Seq: 1})
+	_ = enc.Encode(&Args{Num1: 1, Num2: 2})
+	if _, err := conn.Write(buf.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	dec := gob.NewDecoder(conn)
+	var header codec.Header
+	var reply int
+	if err := dec.Decode(&header); err != nil || header.Seq != 1 || header.Error != "" {
+		t.Fatalf("unexpected response header %+v, err %v", header, err)
+	}
+	if err := dec.Decode(&reply); err != nil || reply != 3 {
+		t.Fatalf("expect 3, got %d, err %v", reply, err)
+	}
+}
+
+func TestHealth(t *testing.T) {
+	server := NewServer()
+	h := server.Health()
+	var foo Foo
+	_ = server.Register(&foo)
+	check := func(service string) ServingStatus {
+		var reply HealthCheckResponse
+		_ = h.Check(HealthCheckRequest{Service: service}, &reply)
+		return reply.Status
+	}
+	_assert(check("") == StatusServing && check("Foo") == StatusServing, "registered services should be serving")
+	_assert(check("Bar") == StatusUnknown, "expect unknown status of a not registered service")
+	h.SetServingStatus("Foo", StatusNotServing)
+	_assert(check("Foo") == StatusNotServing && check("") == StatusServing, "expect the status of Foo only changed")
+
+	done := make(chan ServingStatus)
+	go func() {
+		var reply HealthCheckResponse
+		_ = h.Watch(HealthWatchRequest{Status: StatusServing, Wait: time.Second * 5}, &reply)
+		done <- reply.Status
+	}()
+	time.Sleep(time.Millisecond * 10)
+	h.Shutdown()
+	select {
+	case status := <-done:
+		_assert(status == StatusDraining, "expect draining after shutdown, got %s", status)
+	case <-time.After(time.Second):
+		t.Fatal("Watch should return once the status changes")
+	}
+	h.Resume()
+	_assert(check("Foo") == StatusNotServing, "expect the status of Foo back after resume")
+
+	h.maxWatchers = 1
+	go func() {
+		var reply HealthCheckResponse
+		_ = h.Watch(HealthWatchRequest{Status: StatusServing, Wait: time.Second * 5}, &reply)
+		done <- reply.Status
+	}()
+	time.Sleep(time.Millisecond * 10)
+	start := time.Now()
+	var reply HealthCheckResponse
+	_ = h.Watch(HealthWatchRequest{Status: StatusServing, Wait: time.Second * 5}, &reply)
+	_assert(time.Since(start) < time.Second && reply.Status == StatusServing, "expect Watch over the limit to return at once")
+	h.Shutdown()
+	<-done
+}
+
+func TestServer_EnableHealth(t *testing.T) {
+	server := NewServer()
+	var foo Foo
+	_assert(server.RegisterName("Health", &foo) == nil, "a user service named Health should be registered without EnableHealth")
+	_assert(server.EnableHealth() != nil, "expect error enabling Health over a user service")
+
+	server = NewServer()
+	_, _, err := server.findService("Health.Check")
+	_assert(err != nil, "Health shouldn't be registered without EnableHealth")
+	_assert(server.EnableHealth() == nil, "failed to enable Health")
+	_, _, err = server.findService("Health.Check")
+	_assert(err == nil, "failed to find Health.Check: %v", err)
+}
+
+type Node struct {
+	Value int
+	Next  *Node
+	Tags  map[string][]string
+}
+
+type Tree int
+
+func (t Tree) Walk(args Node, reply *[]int) error { return nil }
+
+func TestReflection(t *testing.T) {
+	server := NewServer()
+	var foo Foo
+	var tree Tree
+	_ = server.Register(&foo)
+	_ = server.Register(&tree)
+	_assert(server.EnableReflection() == nil, "failed to enable reflection")
+	r := &Reflection{server: server}
+
+	var list ListServicesResponse
+	_ = r.ListServices(ListServicesRequest{}, &list)
+	_assert(len(list.Services) == 3 && list.Services[0].Name == "Foo" && list.Services[0].Methods[0] == "Sum",
+		"expect Foo, Reflection and Tree, got %v", list.Services)
+
+	var desc ServiceDescriptor
+	_assert(r.Describe(DescribeRequest{Service: "Foo", Method: "Sum"}, &desc) == nil, "failed to describe Foo.Sum")
+	arg := desc.Methods[0].ArgType
+	_assert(arg.Kind == "struct" && len(arg.Fields) == 2 && arg.Fields[1].Name == "Num2" && arg.Fields[1].Type.Kind == "int",
+		"wrong descriptor of Args: %+v", arg)
+	_assert(desc.Methods[0].ReplyType.Kind == "ptr" && desc.Methods[0].ReplyType.Elem.Kind == "int", "wrong descriptor of reply")
+
+	desc = ServiceDescriptor{}
+	_ = r.Describe(DescribeRequest{Service: "Tree"}, &desc)
+	node := desc.Methods[0].ArgType
+	_assert(len(node.Fields) == 3 && node.Fields[1].Type.Elem.Name == "service.Node" && node.Fields[1].Type.Elem.Fields == nil,
+		"a recursive type should be described once: %+v", node.Fields[1].Type.Elem)
+	_assert(node.Fields[2].Type.Key.Kind == "string" && node.Fields[2].Type.Elem.Kind == "slice", "wrong descriptor of map")
+	_assert(r.Describe(DescribeRequest{Service: "Bar"}, &desc) != nil, "expect error describing an unknown service")
+}
+
+type slow struct{ release chan struct{} }
+
+func (s *slow) Wait(args int, reply *int) error {
+	<-s.release
+	*reply = args
+	return nil
+}
+
+func TestServer_RegisterNameAndUnregister(t *testing.T) {
+	server := NewServer()
+	s := &slow{release: make(chan struct{})}
+	_assert(server.Register(s) != nil, "expect error registering an unexported type without a name")
+	_assert(server.RegisterName("Slow", s) == nil, "failed to register Slow")
+	_assert(server.RegisterName("Slow2", &slow{release: s.release}) == nil, "failed to register a second instance")
+	_assert(server.RegisterName("Slow", s) != nil, "expect error registering Slow twice")
+
+	svc, mType, err := server.findService("Slow.Wait")
+	_assert(err == nil, "failed to find Slow.Wait: %v", err)
+	called := make(chan error, 1)
+	go func() {
+		argv, replyv := mType.newArgv(), mType.newReplyv()
+		called <- svc.call(mType, argv, replyv)
+	}()
+	time.Sleep(time.Millisecond * 10)
+
+	unregistered := make(chan struct{})
+	go func() {
+		_ = server.Unregister("Slow")
+		close(unregistered)
+	}()
+	time.Sleep(time.Millisecond * 10)
+	_, _, err = server.findService("Slow.Wait")
+	_assert(err != nil, "new calls shouldn't be routed to an unregistered service")
+	select {
+	case <-unregistered:
+		t.Fatal("Unregister should wait for the call in flight")
+	default:
+	}
+	close(s.release)
+	_assert(<-called == nil, "the call in flight should complete")
+	<-unregistered
+	_assert(svc.call(mType, mType.newArgv(), mType.newReplyv()) != nil, "calls after Unregister should fail")
+	_assert(server.Unregister("Slow") != nil, "expect error unregistering Slow twice")
+	_, _, err = server.findService("Slow2.Wait")
+	_assert(err == nil, "the second instance should be still registered")
+}
+
+func TestServer_RegisterNilPointer(t *testing.T) {
+	server := NewServer()
+	_assert(server.Register((*Foo)(nil)) != nil, "expect error registering a nil pointer")
+	_assert(server.RegisterName("Foo", (*Foo)(nil)) != nil, "expect error registering a nil pointer with a name")
+	_assert(server.Register(nil) != nil, "expect error registering nil")
+}
+
+// admin unregisters itself from the server in Leave
+type admin struct {
+	server *Server
+	left   chan error
+}
+
+func (a *admin) Leave(args int, reply *int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	a.left <- a.server.UnregisterContext(ctx, "Admin")
+	return nil
+}
+
+func TestServer_UnregisterContext(t *testing.T) {
+	server := NewServer()
+	a := &admin{server: server, left: make(chan error, 1)}
+	_assert(server.RegisterName("Admin", a) == nil, "failed to register Admin")
+	svc, mType, _ := server.findService("Admin.Leave")
+	go func() { _ = svc.call(mType, mType.newArgv(), mType.newReplyv()) }()
+	select {
+	case err := <-a.left:
+		_assert(errors.Is(err, context.DeadlineExceeded), "expect the deadline to stop waiting for the call itself, got %v", err)
+	case <-time.After(time.Second):
+		t.Fatal("a service unregistering itself with UnregisterContext shouldn't block")
+	}
+	_, _, err := server.findService("Admin.Leave")
+	_assert(err != nil, "the service should be removed even if its calls haven't completed")
+}
+
+type Mixed int
+
+type private struct{ N int }
+
+func (m Mixed) Good(args int, reply *int) error         { return nil }
+func (m Mixed) NoReply(args int) error                  { return nil }
+func (m Mixed) NoError(args int, reply *int)            {}
+func (m Mixed) WrongResult(args int, reply *int) string { return "" }
+func (m Mixed) Private(args private, reply *int) error  { return nil }
+func (m Mixed) ValueReply(args int, reply int) error    { return nil }
+
+type recordLogger struct{ lines []string }
+
+func (l *recordLogger) Printf(format string, v ...interface{}) {
+	l.lines = append(l.lines, fmt.Sprintf(format, v...))
+}
+
+func TestServer_SkippedMethods(t *testing.T) {
+	logger := &recordLogger{}
+	server := NewServer(WithLogger(logger))
+	var m Mixed
+	_assert(server.Register(&m) == nil, "failed to register Mixed")
+	skipped := server.SkippedMethods("Mixed")
+	reasons := map[string]string{}
+	for _, s := range skipped {
+		reasons[s.Name] = s.Reason
+	}
+	_assert(len(skipped) == 5, "expect 5 methods skipped, got %v", skipped)
+	_assert(reasons["NoReply"] == "has 1 parameters, want 2 (args, *reply)", "wrong reason %q", reasons["NoReply"])
+	_assert(reasons["NoError"] == "has 0 results, want 1 of type error", "wrong reason %q", reasons["NoError"])
+	_assert(reasons["WrongResult"] == "returns string, want error", "wrong reason %q", reasons["WrongResult"])
+	_assert(reasons["Private"] == "args type service.private is not exported", "wrong reason %q", reasons["Private"])
+	_assert(reasons["ValueReply"] == "reply type int is not a pointer", "wrong reason %q", reasons["ValueReply"])
+	_assert(len(logger.lines) == 6, "expect 5 skipped and 1 registered logged, got %v", logger.lines)
+
+	strict := NewServer(WithStrictRegistration(), WithLogger(nil))
+	err := strict.Register(&m)
+	regErr, ok := err.(*RegistrationError)
+	_assert(ok && regErr.Service == "Mixed" && len(regErr.Skipped) == 5, "expect a RegistrationError, got %v", err)
+	_, _, err = strict.findService("Mixed.Good")
+	_assert(err != nil, "a service failing strict registration shouldn't be registered")
+	var foo Foo
+	_assert(strict.Register(&foo) == nil, "a valid service should pass strict registration")
+}
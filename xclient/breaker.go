@@ -0,0 +1,190 @@
+package xclient
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// BreakerState is the state of a circuit breaker
+type BreakerState int
+
+const (
+	StateClosed   BreakerState = iota // calls pass through, failures are counted
+	StateOpen                         // calls are rejected until the cool-down passes
+	StateHalfOpen                     // a limited number of probes decide recovery
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateOpen:
+		return "open"
+	case StateHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+var ErrCircuitOpen = errors.New("rpc xclient: all circuit breakers are open")
+
+// BreakerConfig configures the circuit breaker kept for every server address.
+// Failures are errors of the connection and timeouts, errors returned by the methods don't count.
+type BreakerConfig struct {
+	FailureRatio   float64       // open the circuit when failures/requests in a window reach it
+	MinRequests    int           // the ratio is only evaluated after this many requests in a window
+	Window         time.Duration // counts are reset every window in closed state, 0 means never
+	CoolDown       time.Duration // how long an open circuit waits before letting probes through
+	HalfOpenProbes int           // successful probes needed to close the circuit again
+	// OnStateChange is called without any lock held whenever a circuit changes its state
+	OnStateChange func(addr string, from, to BreakerState)
+}
+
+var DefaultBreakerConfig = BreakerConfig{
+	FailureRatio:   0.5,
+	MinRequests:    10,
+	Window:         time.Second * 10,
+	CoolDown:       time.Second * 5,
+	HalfOpenProbes: 1,
+}
+
+// BreakerStats is a snapshot of a circuit breaker
+type BreakerStats struct {
+	State    BreakerState
+	Requests int // requests in the current window
+	Failures int // failures in the current window
+	OpenedAt time.Time
+}
+
+type circuitBreaker struct {
+	addr string
+	cfg  *BreakerConfig
+
+	mu          sync.Mutex // protect following
+	state       BreakerState
+	requests    int
+	failures    int
+	windowStart time.Time
+	openedAt    time.Time
+	probing     int // probes in flight in half-open state
+	successes   int // successful probes in half-open state
+}
+
+func newCircuitBreaker(addr string, cfg *BreakerConfig) *circuitBreaker {
+	return &circuitBreaker{addr: addr, cfg: cfg, windowStart: time.Now()}
+}
+
+// allow reports whether a call may be sent to the address now,
+// in half-open state a permitted call is a probe and must report its result.
+func (cb *circuitBreaker) allow() bool {
+	cb.mu.Lock()
+	from := cb.state
+	allowed := true
+	switch cb.state {
+	case StateOpen:
+		if time.Since(cb.openedAt) < cb.cfg.CoolDown {
+			allowed = false
+			break
+		}
+		cb.setState(StateHalfOpen)
+		fallthrough
+	case StateHalfOpen:
+		if cb.probing >= cb.halfOpenProbes() {
+			allowed = false
+			break
+		}
+		cb.probing++
+	}
+	to := cb.state
+	cb.mu.Unlock()
+	cb.notify(from, to)
+	return allowed
+}
+
+// ready reports whether allow would permit a call now, without taking a probe slot
+func (cb *circuitBreaker) ready() bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	switch cb.state {
+	case StateOpen:
+		return time.Since(cb.openedAt) >= cb.cfg.CoolDown
+	case StateHalfOpen:
+		return cb.probing < cb.halfOpenProbes()
+	default:
+		return true
+	}
+}
+
+// onResult records the result of a call to the address
+func (cb *circuitBreaker) onResult(failed bool) {
+	cb.mu.Lock()
+	from := cb.state
+	switch cb.state {
+	case StateClosed:
+		if cb.cfg.Window > 0 && time.Since(cb.windowStart) > cb.cfg.Window {
+			cb.requests, cb.failures = 0, 0
+			cb.windowStart = time.Now()
+		}
+		cb.requests++
+		if failed {
+			cb.failures++
+		}
+		if cb.requests >= cb.cfg.MinRequests && float64(cb.failures) >= cb.cfg.FailureRatio*float64(cb.requests) {
+			cb.setState(StateOpen)
+		}
+	case StateHalfOpen:
+		if cb.probing > 0 {
+			cb.probing--
+		}
+		if failed {
+			cb.setState(StateOpen)
+		} else if cb.successes++; cb.successes >= cb.halfOpenProbes() {
+			cb.setState(StateClosed)
+		}
+	}
+	to := cb.state
+	cb.mu.Unlock()
+	cb.notify(from, to)
+}
+
+// release gives back a probe slot of a call whose result says nothing about the server,
+// e.g. the call was canceled by the caller.
+func (cb *circuitBreaker) release() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	if cb.state == StateHalfOpen && cb.probing > 0 {
+		cb.probing--
+	}
+}
+
+func (cb *circuitBreaker) stats() BreakerStats {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	return BreakerStats{State: cb.state, Requests: cb.requests, Failures: cb.failures, OpenedAt: cb.openedAt}
+}
+
+// setState must be called with cb.mu held
+func (cb *circuitBreaker) setState(state BreakerState) {
+	cb.state = state
+	cb.requests, cb.failures = 0, 0
+	cb.probing, cb.successes = 0, 0
+	cb.windowStart = time.Now()
+	if state == StateOpen {
+		cb.openedAt = time.Now()
+	}
+}
+
+func (cb *circuitBreaker) halfOpenProbes() int {
+	if cb.cfg.HalfOpenProbes <= 0 {
+		return 1
+	}
+	return cb.cfg.HalfOpenProbes
+}
+
+func (cb *circuitBreaker) notify(from, to BreakerState) {
+	if from != to && cb.cfg.OnStateChange != nil {
+		cb.cfg.OnStateChange(cb.addr, from, to)
+	}
+}
@@ -0,0 +1,197 @@
+package xclient
+
+import (
+	"context"
+	"fmt"
+	"hash/crc32"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+const defaultVirtualNodes = 100
+
+// HashKeyFunc derives the hash key of a call for ConsistentHashSelect
+type HashKeyFunc func(serviceMethod string, args interface{}) string
+
+// defaultHashKey uses the printed args as the key, pointers to args are followed so that
+// args passed by pointer don't print their address. Pointers inside args are still printed
+// as addresses, such args need a HashKeyFunc.
+func defaultHashKey(_ string, args interface{}) string {
+	v := reflect.ValueOf(args)
+	for v.Kind() == reflect.Ptr && !v.IsNil() {
+		v = v.Elem()
+	}
+	if !v.IsValid() {
+		return ""
+	}
+	return fmt.Sprintf("%v", v.Interface())
+}
+
+type hashKeyKey struct{}
+
+// ContextWithHashKey sets the hash key used by ConsistentHashSelect for calls made with the returned context,
+// it takes precedence over the HashKeyFunc.
+func ContextWithHashKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, hashKeyKey{}, key)
+}
+
+// WithHashKeyFunc sets how ConsistentHashSelect derives the hash key from a call
+func WithHashKeyFunc(f HashKeyFunc) XClientOption {
+	return func(xc *XClient) {
+		xc.hashKey = f
+	}
+}
+
+// WithVirtualNodes sets the number of virtual nodes of every server on the hash ring
+func WithVirtualNodes(n int) XClientOption {
+	return func(xc *XClient) {
+		xc.replicas = n
+	}
+}
+
+type consistentHashSelector struct {
+	noFeedback
+	ring    *hashRing
+	hashKey HashKeyFunc
+}
+
+// NewConsistentHashSelector returns a Selector that sends calls with the same hash key to the same server,
+// replicas is the number of virtual nodes of every server, keyFunc derives the key of calls
+// without ContextWithHashKey and defaults to the printed args.
+func NewConsistentHashSelector(replicas int, keyFunc HashKeyFunc) Selector {
+	if keyFunc == nil {
+		keyFunc = defaultHashKey
+	}
+	return &consistentHashSelector{ring: newHashRing(replicas), hashKey: keyFunc}
+}
+
+func (s *consistentHashSelector) Select(ctx context.Context, serviceMethod string, args interface{}, servers []string) (string, error) {
+	key, ok := ctx.Value(hashKeyKey{}).(string)
+	if !ok {
+		key = s.hashKey(serviceMethod, args)
+	}
+	return s.ring.get(servers, key), nil
+}
+
+// UpdateServers keeps the ring in sync with all the servers in discovery,
+// servers excluded from a call stay on the ring and are skipped by get.
+func (s *consistentHashSelector) UpdateServers(servers []string) {
+	s.ring.sync(servers)
+}
+
+// hashRing is a consistent hash ring with virtual nodes,
+// servers added or removed only move the keys of their own virtual nodes.
+type hashRing struct {
+	replicas int
+	mu       sync.Mutex        // protect following
+	keys     []uint32          // sorted hashes of virtual nodes
+	nodes    map[uint32]string // hash of virtual node -> server
+	members  map[string]bool
+}
+
+func newHashRing(replicas int) *hashRing {
+	if replicas <= 0 {
+		replicas = defaultVirtualNodes
+	}
+	return &hashRing{replicas: replicas, nodes: make(map[uint32]string), members: make(map[string]bool)}
+}
+
+// sync adds new servers to the ring and removes servers that are gone,
+// the ring is only rebuilt if the servers have changed.
+func (r *hashRing) sync(servers []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(servers) == len(r.members) {
+		same := true
+		for _, s := range servers {
+			if !r.members[s] {
+				same = false
+				break
+			}
+		}
+		if same {
+			return
+		}
+	}
+	current := make(map[string]bool, len(servers))
+	for _, s := range servers {
+		current[s] = true
+	}
+	for s := range r.members {
+		if !current[s] {
+			r.remove(s)
+		}
+	}
+	for _, s := range servers {
+		if !r.members[s] {
+			r.add(s)
+		}
+	}
+	r.sortKeys()
+}
+
+// add puts the virtual nodes of s on the ring, r.mu must be held and r.sortKeys called after
+func (r *hashRing) add(s string) {
+	r.members[s] = true
+	for i := 0; i < r.replicas; i++ {
+		h := r.hash(strconv.Itoa(i) + s)
+		if _, taken := r.nodes[h]; taken {
+			continue // a collision, the virtual node stays with the server that has it
+		}
+		r.nodes[h] = s
+	}
+}
+
+// remove takes the virtual nodes of s off the ring, r.mu must be held and r.sortKeys called after
+func (r *hashRing) remove(s string) {
+	delete(r.members, s)
+	for i := 0; i < r.replicas; i++ {
+		if h := r.hash(strconv.Itoa(i) + s); r.nodes[h] == s {
+			delete(r.nodes, h)
+		}
+	}
+}
+
+func (r *hashRing) sortKeys() {
+	r.keys = r.keys[:0]
+	for h := range r.nodes {
+		r.keys = append(r.keys, h)
+	}
+	sort.Slice(r.keys, func(i, j int) bool { return r.keys[i] < r.keys[j] })
+}
+
+// get returns the first server in servers clockwise from key. Servers on the ring but not
+// in servers are skipped, servers not on the ring yet are added.
+func (r *hashRing) get(servers []string, key string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	candidates := make(map[string]bool, len(servers))
+	added := false
+	for _, s := range servers {
+		candidates[s] = true
+		if !r.members[s] {
+			r.add(s)
+			added = true
+		}
+	}
+	if added {
+		r.sortKeys()
+	}
+	h := r.hash(key)
+	i := sort.Search(len(r.keys), func(i int) bool { return r.keys[i] >= h })
+	for n := 0; n < len(r.keys); n++ {
+		if s := r.nodes[r.keys[(i+n)%len(r.keys)]]; candidates[s] {
+			return s
+		}
+	}
+	if len(servers) > 0 {
+		return servers[0] // every virtual node of servers has collided
+	}
+	return ""
+}
+
+func (r *hashRing) hash(key string) uint32 {
+	return crc32.ChecksumIEEE([]byte(key))
+}
@@ -1,20 +1,27 @@
 package xclient
 
 import (
+	"context"
 	"errors"
-	"math"
-	"math/rand"
+	"net/url"
+	"strconv"
+	"strings"
 	"sync"
-	"time"
 )
 
 type SelectMode int
 
 const (
-	RandomSelect     SelectMode = iota // select randomly
-	RoundRobinSelect                   // select using Robbin algorithm
+	RandomSelect             SelectMode = iota // select randomly
+	RoundRobinSelect                           // select using Robbin algorithm
+	WeightedRoundRobinSelect                   // select using smooth weighted round robin
+	ConsistentHashSelect                       // select by the hash key of the call
+	P2CSelect                                  // the less loaded of two random servers, needs feedback from XClient
+	LeastActiveSelect                          // the server with the fewest in-flight calls, needs feedback from XClient
 )
 
+var errNoServers = errors.New("rpc discovery: no available servers")
+
 type Discovery interface {
 	Refresh() error // refresh from remote registry
 	Update(servers []string) error
@@ -27,10 +34,10 @@ var _ Discovery = (*MultiServersDiscovery)(nil)
 // MultiServersDiscovery is a discovery for multi servers without a registry center
 // user provides the server addresses explicitly instead
 type MultiServersDiscovery struct {
-	r       *rand.Rand   // generate random number
-	mu      sync.RWMutex // protect following
-	servers []string
-	index   int // record the selected position for robin algorithm
+	mu        sync.RWMutex // protect following
+	servers   []string
+	weights   map[string]int // weight of servers, parsed from "addr?weight=5"
+	selectors map[SelectMode]Selector
 }
 
 // Refresh doesn't make sense for MultiServersDiscovery, so ignore it
@@ -42,28 +49,74 @@ func (d *MultiServersDiscovery) Refresh() error {
 func (d *MultiServersDiscovery) Update(servers []string) error {
 	d.mu.Lock()
 	defer d.mu.Unlock()
-	d.servers = servers
+	d.setServers(servers)
 	return nil
 }
 
-// Get a server according to mode
+// setServers replaces the servers, weights given as "addr?weight=5" are stripped from the address.
+// An address listed more than once is kept once with the sum of its weights. d.mu must be held.
+func (d *MultiServersDiscovery) setServers(servers []string) {
+	d.servers = make([]string, 0, len(servers))
+	d.weights = make(map[string]int, len(servers))
+	for _, server := range servers {
+		addr, weight := parseServer(server)
+		if _, ok := d.weights[addr]; !ok {
+			d.servers = append(d.servers, addr)
+		}
+		d.weights[addr] += weight
+	}
+}
+
+// parseServer splits "addr?weight=5" into the address and its weight, the weight is 1 by default
+func parseServer(server string) (string, int) {
+	i := strings.Index(server, "?")
+	if i < 0 {
+		return server, 1
+	}
+	addr, weight := server[:i], 1
+	if query, err := url.ParseQuery(server[i+1:]); err == nil {
+		if w, err := strconv.Atoi(query.Get("weight")); err == nil && w > 0 {
+			weight = w
+		}
+	}
+	return addr, weight
+}
+
+// Weight returns the weight of the server, 0 if it doesn't exist
+func (d *MultiServersDiscovery) Weight(addr string) int {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.weights[addr]
+}
+
+// Get a server according to mode, it is done by the built-in Selector of mode
 func (d *MultiServersDiscovery) Get(mode SelectMode) (string, error) {
+	servers, _ := d.GetAll()
+	if len(servers) == 0 {
+		return "", errNoServers
+	}
+	selector, err := d.selector(mode)
+	if err != nil {
+		return "", err
+	}
+	if t, ok := selector.(ServerTracker); ok {
+		t.UpdateServers(servers)
+	}
+	return selector.Select(context.Background(), "", nil, servers)
+}
+
+func (d *MultiServersDiscovery) selector(mode SelectMode) (Selector, error) {
 	d.mu.Lock()
 	defer d.mu.Unlock()
-	n := len(d.servers)
-	if n == 0 {
-		return "", errors.New("rpc discovery: no available servers")
-	}
-	switch mode {
-	case RandomSelect:
-		return d.servers[d.r.Intn(n)], nil
-	case RoundRobinSelect:
-		s := d.servers[d.index%n] // servers could be updated, so mode n to ensure safety
-		d.index = (d.index + 1) % n
+	if s, ok := d.selectors[mode]; ok {
 		return s, nil
-	default:
-		return "", errors.New("rpc discovery: not supported select mode")
 	}
+	s, err := NewSelector(mode, d)
+	if err != nil {
+		return nil, err
+	}
+	d.selectors[mode] = s
+	return s, nil
 }
 
 // returns all servers in discovery
@@ -78,10 +131,7 @@ func (d *MultiServersDiscovery) GetAll() ([]string, error) {
 
 // NewMultiServerDiscovery creates a MultiServersDiscovery instance
 func NewMultiServerDiscovery(servers []string) *MultiServersDiscovery {
-	d := &MultiServersDiscovery{
-		servers: servers,
-		r:       rand.New(rand.NewSource(time.Now().UnixNano())),
-	}
-	d.index = d.r.Intn(math.MaxInt32 - 1)
+	d := &MultiServersDiscovery{selectors: make(map[SelectMode]Selector)}
+	d.setServers(servers)
 	return d
 }
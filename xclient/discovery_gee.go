@@ -1,15 +1,25 @@
 package xclient
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"geeRPC/registry"
 	"log"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
+// GeeRegistryDiscovery refreshes the servers from the registry after a timeout,
+// registry may be a comma separated list of registries of a cluster to fail over between.
 type GeeRegistryDiscovery struct {
 	*MultiServersDiscovery
-	registry   string
+	registry   *registryList
+	filter     registry.ServerFilter
 	timeout    time.Duration
 	lastUpdate time.Time
 }
@@ -19,32 +29,100 @@ const defaultUpdateTimeout = time.Second * 10
 func (d *GeeRegistryDiscovery) Update(servers []string) error {
 	d.mu.Lock()
 	defer d.mu.Unlock()
-	d.servers = servers
+	d.setServers(servers)
 	d.lastUpdate = time.Now()
 	return nil
 }
 
+// SetFilter makes the discovery only return servers selected by f, e.g. scoped to a namespace
+// and a service by registry.ServerFilter{Namespace: "prod", Service: "Arith"}. It takes effect at once.
+func (d *GeeRegistryDiscovery) SetFilter(f registry.ServerFilter) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.filter = f
+	d.lastUpdate = time.Time{}
+}
+
 func (d *GeeRegistryDiscovery) Refresh() error {
 	d.mu.Lock()
 	defer d.mu.Unlock()
 	if d.lastUpdate.Add(d.timeout).After(time.Now()) {
 		return nil
 	}
-	log.Println("rpc registry: refresh servers from registry", d.registry)
-	resp, err := http.Get(d.registry)
-	if err != nil {
+	var servers []string
+	var err error
+	for i := 0; i < d.registry.len(); i++ {
+		reg := d.registry.get()
+		log.Println("rpc registry: refresh servers from registry", reg)
+		if servers, _, err = fetchServers(context.Background(), http.DefaultClient, reg, d.filter.Values()); err == nil {
+			break
+		}
 		log.Println("rpc registry refresh err:", err)
+		d.registry.failed(reg)
+	}
+	if err != nil {
 		return err
 	}
-	servers := strings.Split(resp.Header.Get("X-Geerpc-Servers"), ",")
-	d.servers = make([]string, 0, len(servers))
+	d.setServers(servers)
+	d.lastUpdate = time.Now()
+	return nil
+}
+
+// fetchServers gets servers from the registry with q added to the query of registryAddr,
+// it returns the servers as "addr?weight=5" and the index of the registry.
+func fetchServers(ctx context.Context, httpClient *http.Client, registryAddr string, q url.Values) ([]string, string, error) {
+	u, err := url.Parse(registryAddr)
+	if err != nil {
+		return nil, "", err
+	}
+	query := u.Query()
+	for k, v := range q {
+		query[k] = v
+	}
+	u.RawQuery = query.Encode()
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	if err != nil {
+		return nil, "", err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	index := resp.Header.Get("X-Geerpc-Index")
+	if !strings.Contains(resp.Header.Get("Content-Type"), "application/json") {
+		// the registry doesn't support metadata
+		return parseServers(resp.Header.Get("X-Geerpc-Servers")), index, nil
+	}
+	var items []*registry.ServerItem
+	if err := json.NewDecoder(resp.Body).Decode(&items); err != nil {
+		return nil, "", err
+	}
+	servers := make([]string, 0, len(items))
+	for _, item := range items {
+		if item.Weight > 0 {
+			servers = append(servers, item.Addr+"?weight="+strconv.Itoa(item.Weight))
+		} else {
+			servers = append(servers, item.Addr)
+		}
+	}
+	return servers, index, nil
+}
+
+// parseServers splits the X-Geerpc-Servers header returned by the registry
+func parseServers(header string) []string {
+	servers := strings.Split(header, ",")
+	alive := make([]string, 0, len(servers))
 	for _, server := range servers {
 		if strings.TrimSpace(server) != "" {
-			d.servers = append(d.servers, strings.TrimSpace(server))
+			alive = append(alive, strings.TrimSpace(server))
 		}
 	}
-	d.lastUpdate = time.Now()
-	return nil
+	return alive
 }
 
 func (d *GeeRegistryDiscovery) Get(mode SelectMode) (string, error) {
@@ -67,8 +145,45 @@ func NewGeeRegistryDiscovery(registerAddr string, timeout time.Duration) *GeeReg
 	}
 	d := &GeeRegistryDiscovery{
 		MultiServersDiscovery: NewMultiServerDiscovery(make([]string, 0)),
-		registry:              registerAddr,
+		registry:              newRegistryList(registerAddr),
 		timeout:               timeout,
 	}
 	return d
 }
+
+// registryList is a list of registries replicating each other, requests go to
+// the current one and fail over to the next one when it fails.
+type registryList struct {
+	mu      sync.Mutex
+	addrs   []string
+	current int
+}
+
+// newRegistryList parses a comma separated list of registries
+func newRegistryList(registryAddrs string) *registryList {
+	l := &registryList{addrs: registry.SplitRegistries(registryAddrs)}
+	if len(l.addrs) == 0 {
+		l.addrs = []string{registryAddrs}
+	}
+	return l
+}
+
+func (l *registryList) len() int {
+	return len(l.addrs)
+}
+
+// get returns the current registry
+func (l *registryList) get() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.addrs[l.current]
+}
+
+// failed moves to the next registry if addr is still the current one
+func (l *registryList) failed(addr string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.addrs[l.current] == addr {
+		l.current = (l.current + 1) % len(l.addrs)
+	}
+}
@@ -0,0 +1,132 @@
+package xclient
+
+import (
+	"context"
+	"fmt"
+	"geeRPC/registry"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// GeeRegistryWatchDiscovery keeps a long poll on the registry and updates the servers
+// as soon as they change, instead of refreshing them after a timeout.
+// registry may be a comma separated list of registries of a cluster to fail over between.
+type GeeRegistryWatchDiscovery struct {
+	*MultiServersDiscovery
+	registry   *registryList
+	wait       time.Duration
+	httpClient *http.Client
+	ctx        context.Context
+	cancel     context.CancelFunc // stop watching
+
+	fmu        sync.Mutex // protect following
+	filter     registry.ServerFilter
+	filterGen  uint64             // increased on every SetFilter, to drop the result of a stale poll
+	index      uint64             // index of the servers on the current registry, 0 means fetching them at once
+	cancelPoll context.CancelFunc // cancel the running poll
+}
+
+const (
+	defaultWatchWait  = time.Second * 30
+	watchRetryBackoff = time.Second
+)
+
+// NewGeeRegistryWatchDiscovery fetches the servers from the registry once
+// and starts watching it, every long poll lasts at most wait.
+func NewGeeRegistryWatchDiscovery(registerAddr string, wait time.Duration) *GeeRegistryWatchDiscovery {
+	if wait == 0 {
+		wait = defaultWatchWait
+	}
+	d := &GeeRegistryWatchDiscovery{
+		MultiServersDiscovery: NewMultiServerDiscovery(make([]string, 0)),
+		registry:              newRegistryList(registerAddr),
+		wait:                  wait,
+		httpClient:            &http.Client{Timeout: wait + time.Second*10},
+	}
+	d.ctx, d.cancel = context.WithCancel(context.Background())
+	if err := d.poll(0); err != nil {
+		log.Println("rpc registry watch err:", err)
+	}
+	go d.watch()
+	return d
+}
+
+// Refresh doesn't make sense for GeeRegistryWatchDiscovery, servers are pushed by the registry
+func (d *GeeRegistryWatchDiscovery) Refresh() error {
+	return nil
+}
+
+// SetFilter makes the discovery only return servers selected by f, the servers are fetched again at once
+func (d *GeeRegistryWatchDiscovery) SetFilter(f registry.ServerFilter) {
+	d.fmu.Lock()
+	defer d.fmu.Unlock()
+	d.filter = f
+	d.filterGen++
+	d.index = 0
+	if d.cancelPoll != nil {
+		d.cancelPoll()
+	}
+}
+
+// Close stops watching the registry
+func (d *GeeRegistryWatchDiscovery) Close() error {
+	d.cancel()
+	return nil
+}
+
+func (d *GeeRegistryWatchDiscovery) watch() {
+	for d.ctx.Err() == nil {
+		if err := d.poll(d.wait); err != nil && d.ctx.Err() == nil {
+			log.Println("rpc registry watch err:", err)
+			select {
+			case <-d.ctx.Done():
+			case <-time.After(watchRetryBackoff):
+			}
+		}
+	}
+}
+
+// poll asks the registry for servers newer than d.index, waiting at most wait
+func (d *GeeRegistryWatchDiscovery) poll(wait time.Duration) error {
+	ctx, cancel := context.WithCancel(d.ctx)
+	defer cancel()
+	d.fmu.Lock()
+	q := d.filter.Values()
+	last, gen := d.index, d.filterGen
+	d.cancelPoll = cancel
+	d.fmu.Unlock()
+	q.Set("index", strconv.FormatUint(last, 10))
+	q.Set("wait", wait.String())
+	reg := d.registry.get()
+	servers, header, err := fetchServers(ctx, d.httpClient, reg, q)
+	if err != nil {
+		if ctx.Err() != nil && d.ctx.Err() == nil {
+			return nil // canceled by SetFilter
+		}
+		d.registry.failed(reg)
+		d.fmu.Lock()
+		if d.filterGen == gen {
+			// every registry has its own index, fetch the servers from the next one at once
+			d.index = 0
+		}
+		d.fmu.Unlock()
+		return err
+	}
+	index, err := strconv.ParseUint(header, 10, 64)
+	if err != nil {
+		return fmt.Errorf("registry doesn't support watch: %v", err)
+	}
+	d.fmu.Lock()
+	defer d.fmu.Unlock()
+	if d.filterGen != gen {
+		return nil // the filter has changed during the poll
+	}
+	if index != last {
+		d.index = index
+		return d.Update(servers)
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package xclient
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// FailMode decides what XClient does when a call fails
+type FailMode int
+
+const (
+	Failfast FailMode = iota // return the error at once
+	Failover                 // retry on another server
+	Failtry                  // retry on the same server
+	Fork                     // call several servers concurrently and take the first success
+)
+
+var errAllExcluded = errors.New("rpc xclient: no server left to try")
+
+type failModeKey struct{}
+
+// ContextWithFailMode overrides the FailMode of the calls made with the returned context
+func ContextWithFailMode(ctx context.Context, mode FailMode) context.Context {
+	return context.WithValue(ctx, failModeKey{}, mode)
+}
+
+func (xc *XClient) failModeOf(ctx context.Context) FailMode {
+	if mode, ok := ctx.Value(failModeKey{}).(FailMode); ok {
+		return mode
+	}
+	return xc.failMode
+}
+
+// fork sends the call to xc.forks different servers concurrently,
+// returns as soon as one of them succeeds and cancels the rest.
+func (xc *XClient) fork(ctx context.Context, serviceMethod string, args, reply interface{}) error {
+	n := xc.forks
+	if n <= 0 {
+		servers, err := xc.d.GetAll()
+		if err != nil {
+			return err
+		}
+		n = len(servers)
+	}
+	var servers []string
+	picked := make(map[string]bool)
+	for len(servers) < n {
+		rpcAddr, err := xc.pick(ctx, serviceMethod, args, picked)
+		if err != nil {
+			if len(servers) == 0 {
+				return err
+			}
+			break
+		}
+		picked[rpcAddr] = true
+		servers = append(servers, rpcAddr)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var mu sync.Mutex // protect replyDone
+	replyDone := reply == nil
+	done := make(chan error, len(servers))
+	for _, rpcAddr := range servers {
+		go func(rpcAddr string) {
+			clonedReply := cloneReply(reply)
+			err := xc.call(rpcAddr, ctx, serviceMethod, args, clonedReply)
+			if err == nil {
+				mu.Lock()
+				if !replyDone {
+					setReply(reply, clonedReply)
+					replyDone = true
+				}
+				mu.Unlock()
+			}
+			done <- err
+		}(rpcAddr)
+	}
+	var e error
+	for range servers {
+		err := <-done
+		if err == nil {
+			return nil
+		}
+		if e == nil {
+			e = err
+		}
+	}
+	return e
+}
@@ -0,0 +1,81 @@
+package xclient
+
+import (
+	"context"
+	"fmt"
+)
+
+// BroadcastResult is the result of a broadcast call to one server
+type BroadcastResult struct {
+	Reply interface{} // a new value of the type reply points to, nil if the call failed
+	Error error
+}
+
+type gatherResult struct {
+	rpcAddr string
+	result  *BroadcastResult
+}
+
+// Gather invokes the named function for every server registered in discovery,
+// waits for all of them and returns the result of every server by address.
+// reply is set to the first successful reply if it isn't nil.
+func (xc *XClient) Gather(ctx context.Context, serviceMethod string, args, reply interface{}) (map[string]*BroadcastResult, error) {
+	return xc.gather(ctx, serviceMethod, args, reply, 0)
+}
+
+// Quorum invokes the named function for every server registered in discovery and
+// returns as soon as k of them succeed, or fails once k successes become impossible.
+// The results returned are those received so far, the rest of the calls go on
+// until they complete or ctx is done.
+func (xc *XClient) Quorum(ctx context.Context, serviceMethod string, args, reply interface{}, k int) (map[string]*BroadcastResult, error) {
+	if k <= 0 {
+		return nil, fmt.Errorf("rpc xclient: invalid quorum %d", k)
+	}
+	return xc.gather(ctx, serviceMethod, args, reply, k)
+}
+
+// gather calls every server, quorum 0 means waiting for all of them
+func (xc *XClient) gather(ctx context.Context, serviceMethod string, args, reply interface{}, quorum int) (map[string]*BroadcastResult, error) {
+	servers, err := xc.d.GetAll()
+	if err != nil {
+		return nil, err
+	}
+	n := len(servers)
+	if quorum > n {
+		return nil, fmt.Errorf("rpc xclient: quorum %d is more than %d servers", quorum, n)
+	}
+	ch := make(chan gatherResult, n) // buffered, so the calls left behind by Quorum never block
+	for _, rpcAddr := range servers {
+		go func(rpcAddr string) {
+			clonedReply := cloneReply(reply)
+			err := xc.call(rpcAddr, ctx, serviceMethod, args, clonedReply)
+			if err != nil {
+				clonedReply = nil
+			}
+			ch <- gatherResult{rpcAddr: rpcAddr, result: &BroadcastResult{Reply: clonedReply, Error: err}}
+		}(rpcAddr)
+	}
+	results := make(map[string]*BroadcastResult, n)
+	replyDone := reply == nil
+	succeeded, failed := 0, 0
+	for i := 0; i < n; i++ {
+		r := <-ch
+		results[r.rpcAddr] = r.result
+		if r.result.Error != nil {
+			failed++
+		} else {
+			succeeded++
+			if !replyDone {
+				setReply(reply, r.result.Reply)
+				replyDone = true
+			}
+		}
+		if quorum > 0 && succeeded >= quorum {
+			return results, nil
+		}
+		if quorum > 0 && failed > n-quorum {
+			return results, fmt.Errorf("rpc xclient: quorum not reached: %d of %d servers failed, need %d successes", failed, n, quorum)
+		}
+	}
+	return results, nil
+}
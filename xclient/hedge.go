@@ -0,0 +1,174 @@
+package xclient
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// HedgeStats counts hedged requests of an XClient
+type HedgeStats struct {
+	Hedged uint64 // hedged duplicates sent
+	Won    uint64 // calls answered by the hedged duplicate
+}
+
+const (
+	hedgeWindow     = 100 // latencies kept to compute the percentile of a method
+	hedgeMinSamples = 10  // the fallback delay is used until this many latencies are kept
+)
+
+// hedging is allocated separately from XClient, so that the counters at its start
+// are 64-bit aligned for the atomic operations on 32-bit platforms.
+type hedging struct {
+	stats    HedgeStats // updated atomically
+	policies map[string]*hedgePolicy
+}
+
+// hedgePolicy decides the hedging delay of a service method
+type hedgePolicy struct {
+	delay      time.Duration // the static delay, or the fallback of a percentile
+	percentile float64       // 0 means the static delay, otherwise the percentile of the latencies kept
+
+	mu        sync.Mutex      // protect following
+	latencies []time.Duration // ring buffer of the latencies of successful calls
+	next      int
+}
+
+// WithHedging enables request hedging for serviceMethod: if the first server hasn't answered
+// within delay, the same request is sent to a second server and the first success is taken.
+// Only use it for read-only methods, the request may be handled twice.
+func WithHedging(serviceMethod string, delay time.Duration) XClientOption {
+	return withHedgePolicy(serviceMethod, &hedgePolicy{delay: delay})
+}
+
+// WithAdaptiveHedging is WithHedging with the delay following the latency of serviceMethod:
+// the request is hedged once the first server is slower than percentile (e.g. 0.95) of
+// the last successful calls. fallback is the delay until enough calls have been made.
+func WithAdaptiveHedging(serviceMethod string, percentile float64, fallback time.Duration) XClientOption {
+	if percentile <= 0 || percentile > 1 {
+		percentile = 0.95
+	}
+	return withHedgePolicy(serviceMethod, &hedgePolicy{delay: fallback, percentile: percentile})
+}
+
+func withHedgePolicy(serviceMethod string, p *hedgePolicy) XClientOption {
+	return func(xc *XClient) {
+		if xc.hedging == nil {
+			xc.hedging = &hedging{policies: make(map[string]*hedgePolicy)}
+		}
+		xc.hedging.policies[serviceMethod] = p
+	}
+}
+
+// observe keeps the latency of a successful call
+func (p *hedgePolicy) observe(latency time.Duration) {
+	if p.percentile == 0 {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.latencies) < hedgeWindow {
+		p.latencies = append(p.latencies, latency)
+		return
+	}
+	p.latencies[p.next] = latency
+	p.next = (p.next + 1) % hedgeWindow
+}
+
+// hedgeDelay returns how long to wait for the first server before hedging
+func (p *hedgePolicy) hedgeDelay() time.Duration {
+	if p.percentile == 0 {
+		return p.delay
+	}
+	p.mu.Lock()
+	if len(p.latencies) < hedgeMinSamples {
+		p.mu.Unlock()
+		return p.delay
+	}
+	sorted := make([]time.Duration, len(p.latencies))
+	copy(sorted, p.latencies)
+	p.mu.Unlock()
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	i := int(p.percentile*float64(len(sorted))+0.5) - 1
+	if i < 0 {
+		i = 0
+	}
+	return sorted[i]
+}
+
+// HedgeStats returns the hedge counters of xc
+func (xc *XClient) HedgeStats() HedgeStats {
+	if xc.hedging == nil {
+		return HedgeStats{}
+	}
+	return HedgeStats{
+		Hedged: atomic.LoadUint64(&xc.hedging.stats.Hedged),
+		Won:    atomic.LoadUint64(&xc.hedging.stats.Won),
+	}
+}
+
+// invoke calls rpcAddr, hedging the request if it is enabled for serviceMethod
+func (xc *XClient) invoke(rpcAddr string, ctx context.Context, serviceMethod string, args, reply interface{}) error {
+	if xc.hedging == nil {
+		return xc.call(rpcAddr, ctx, serviceMethod, args, reply)
+	}
+	p, ok := xc.hedging.policies[serviceMethod]
+	if !ok {
+		return xc.call(rpcAddr, ctx, serviceMethod, args, reply)
+	}
+	return xc.hedge(rpcAddr, p, ctx, serviceMethod, args, reply)
+}
+
+type hedgeResult struct {
+	reply  interface{}
+	err    error
+	hedged bool
+}
+
+func (xc *XClient) hedge(rpcAddr string, p *hedgePolicy, ctx context.Context, serviceMethod string, args, reply interface{}) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel() // cancel the loser
+	results := make(chan hedgeResult, 2)
+	run := func(rpcAddr string, hedged bool) {
+		clonedReply := cloneReply(reply)
+		start := time.Now()
+		err := xc.call(rpcAddr, ctx, serviceMethod, args, clonedReply)
+		if err == nil {
+			p.observe(time.Since(start))
+		}
+		results <- hedgeResult{reply: clonedReply, err: err, hedged: hedged}
+	}
+	go run(rpcAddr, false)
+	t := time.NewTimer(p.hedgeDelay())
+	defer t.Stop()
+	var e error
+	for inflight := 1; inflight > 0; {
+		select {
+		case <-t.C:
+			hedgeAddr, err := xc.pick(ctx, serviceMethod, args, map[string]bool{rpcAddr: true})
+			if err != nil {
+				continue // no other server, keep waiting for the first one
+			}
+			atomic.AddUint64(&xc.hedging.stats.Hedged, 1)
+			inflight++
+			go run(hedgeAddr, true)
+		case r := <-results:
+			inflight--
+			if r.err == nil {
+				if reply != nil {
+					setReply(reply, r.reply)
+				}
+				if r.hedged {
+					atomic.AddUint64(&xc.hedging.stats.Won, 1)
+				}
+				return nil
+			}
+			if e == nil {
+				e = r.err
+			}
+		}
+	}
+	return e
+}
@@ -0,0 +1,50 @@
+package xclient
+
+import (
+	"context"
+	"math/rand"
+	"sync/atomic"
+)
+
+// leastActiveSelector selects the server with the fewest in-flight calls, ties are broken randomly
+type leastActiveSelector struct {
+	loadTracker
+}
+
+func newLeastActiveSelector() *leastActiveSelector {
+	return new(leastActiveSelector)
+}
+
+func (s *leastActiveSelector) Select(_ context.Context, _ string, _ interface{}, servers []string) (string, error) {
+	var best string
+	var least int64
+	ties := 0
+	for _, server := range servers {
+		active := atomic.LoadInt64(&s.load(server).inflight)
+		switch {
+		case best == "" || active < least:
+			best, least, ties = server, active, 1
+		case active == least:
+			// reservoir sampling, every tied server has the same chance
+			ties++
+			if rand.Intn(ties) == 0 {
+				best = server
+			}
+		}
+	}
+	return best, nil
+}
+
+// inflightCounter is implemented by the load aware selectors
+type inflightCounter interface {
+	InFlight() map[string]int64
+}
+
+// InFlight returns the number of outstanding calls of every server that has been called,
+// it is only tracked by load aware selectors such as P2CSelect and LeastActiveSelect.
+func (xc *XClient) InFlight() map[string]int64 {
+	if c, ok := xc.selector.(inflightCounter); ok {
+		return c.InFlight()
+	}
+	return map[string]int64{}
+}
@@ -0,0 +1,153 @@
+package xclient
+
+import (
+	"sync"
+	"time"
+)
+
+// OutlierConfig configures passive health checking of servers,
+// a server is ejected from selection when it fails too much and comes back after the ejection time.
+// A server healthy for MaxEjectionTime after an ejection has its ejections forgotten,
+// without MaxEjectionTime the ejection time of a server keeps growing.
+// Failures are errors of the connection and timeouts, errors returned by the methods don't count.
+type OutlierConfig struct {
+	ConsecutiveErrors  int           // eject after this many consecutive failures, 0 disables it
+	ErrorRate          float64       // eject when the error rate in an interval reaches it, 0 disables it
+	MinRequests        int           // the error rate is only evaluated after this many requests in an interval
+	Interval           time.Duration // the window of the error rate
+	BaseEjectionTime   time.Duration // a server is ejected for BaseEjectionTime * times it has been ejected
+	MaxEjectionTime    time.Duration // the cap of the ejection time, 0 means no cap
+	MaxEjectionPercent int           // at most this percentage of servers are ejected, but at least one
+}
+
+var DefaultOutlierConfig = OutlierConfig{
+	ConsecutiveErrors:  5,
+	ErrorRate:          0.5,
+	MinRequests:        20,
+	Interval:           time.Second * 10,
+	BaseEjectionTime:   time.Second * 30,
+	MaxEjectionTime:    time.Minute * 5,
+	MaxEjectionPercent: 10,
+}
+
+type hostStatus struct {
+	consecutive  int // consecutive failures
+	requests     int // requests in the current interval
+	failures     int // failures in the current interval
+	windowStart  time.Time
+	ejections    int // times the server has been ejected, decides the ejection time
+	ejectedUntil time.Time
+}
+
+type outlierDetector struct {
+	cfg     OutlierConfig
+	servers func() ([]string, error) // the servers in discovery, they limit how many can be ejected
+	mu      sync.Mutex               // protect following
+	hosts   map[string]*hostStatus
+}
+
+func newOutlierDetector(cfg OutlierConfig, servers func() ([]string, error)) *outlierDetector {
+	return &outlierDetector{cfg: cfg, servers: servers, hosts: make(map[string]*hostStatus)}
+}
+
+// WithOutlierDetection ejects servers that keep failing from selection for an increasing period
+func WithOutlierDetection(cfg OutlierConfig) XClientOption {
+	return func(xc *XClient) {
+		xc.outlier = newOutlierDetector(cfg, xc.d.GetAll)
+	}
+}
+
+func (o *outlierDetector) ejected(rpcAddr string) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	h, ok := o.hosts[rpcAddr]
+	return ok && time.Now().Before(h.ejectedUntil)
+}
+
+func (o *outlierDetector) onResult(rpcAddr string, failed bool) {
+	var servers []string
+	if failed {
+		// got without o.mu, discovery may refresh the servers from a registry
+		servers, _ = o.servers()
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	now := time.Now()
+	h, ok := o.hosts[rpcAddr]
+	if !ok {
+		h = &hostStatus{windowStart: now}
+		o.hosts[rpcAddr] = h
+	}
+	if now.Before(h.ejectedUntil) {
+		return // results of calls sent before the ejection
+	}
+	if o.cfg.Interval > 0 && now.Sub(h.windowStart) > o.cfg.Interval {
+		h.requests, h.failures = 0, 0
+		h.windowStart = now
+	}
+	h.requests++
+	if !failed {
+		h.consecutive = 0
+		if h.ejections > 0 && o.cfg.MaxEjectionTime > 0 && now.Sub(h.ejectedUntil) > o.cfg.MaxEjectionTime {
+			h.ejections = 0 // it has been healthy for long, forget the ejections
+		}
+		return
+	}
+	h.consecutive++
+	h.failures++
+	if (o.cfg.ConsecutiveErrors > 0 && h.consecutive >= o.cfg.ConsecutiveErrors) ||
+		(o.cfg.ErrorRate > 0 && h.requests >= o.cfg.MinRequests && float64(h.failures) >= o.cfg.ErrorRate*float64(h.requests)) {
+		o.eject(h, now, servers)
+	}
+}
+
+// eject must be called with o.mu held. The limit is decided by servers, the servers in discovery now,
+// servers that have left don't count.
+func (o *outlierDetector) eject(h *hostStatus, now time.Time, servers []string) {
+	poolSize := len(servers)
+	if poolSize == 0 {
+		return
+	}
+	max := poolSize * o.cfg.MaxEjectionPercent / 100
+	if max < 1 {
+		max = 1
+	}
+	if max >= poolSize {
+		max = poolSize - 1 // never eject all servers
+	}
+	ejected := 0
+	for _, rpcAddr := range servers {
+		if other, ok := o.hosts[rpcAddr]; ok && now.Before(other.ejectedUntil) {
+			ejected++
+		}
+	}
+	if ejected >= max {
+		return
+	}
+	h.ejections++
+	d := o.cfg.BaseEjectionTime * time.Duration(h.ejections)
+	if o.cfg.MaxEjectionTime > 0 && d > o.cfg.MaxEjectionTime {
+		d = o.cfg.MaxEjectionTime
+	}
+	h.ejectedUntil = now.Add(d)
+	h.consecutive, h.requests, h.failures = 0, 0, 0
+	h.windowStart = h.ejectedUntil
+}
+
+// Ejected returns the servers ejected by outlier detection and when they come back
+func (xc *XClient) Ejected() map[string]time.Time {
+	ejected := make(map[string]time.Time)
+	if xc.outlier == nil {
+		return ejected
+	}
+	o := xc.outlier
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	now := time.Now()
+	for rpcAddr, h := range o.hosts {
+		if now.Before(h.ejectedUntil) {
+			ejected[rpcAddr] = h.ejectedUntil
+		}
+	}
+	return ejected
+}
@@ -0,0 +1,132 @@
+package xclient
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	ewmaDecay    = time.Second * 10 // the weight of a latency sample halves in about 7s
+	errorPenalty = time.Second      // failed calls count as this slow, so failing fast doesn't attract traffic
+)
+
+// nodeLoad tracks the load of a server from the calls made to it
+type nodeLoad struct {
+	inflight int64 // updated atomically
+
+	mu   sync.Mutex // protect following
+	ewma float64    // exponentially weighted moving average of latency in nanoseconds
+	last time.Time  // time of the last sample
+}
+
+func (l *nodeLoad) observe(latency time.Duration, failed bool) {
+	if failed && latency < errorPenalty {
+		latency = errorPenalty
+	}
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.last.IsZero() {
+		l.ewma = float64(latency)
+	} else {
+		w := math.Exp(-float64(now.Sub(l.last)) / float64(ewmaDecay))
+		l.ewma = l.ewma*w + float64(latency)*(1-w)
+	}
+	l.last = now
+}
+
+// score is the expected latency of a new call, lower is better.
+// ok is false if no call to the server has completed yet, its latency is unknown.
+func (l *nodeLoad) score() (score float64, ok bool) {
+	l.mu.Lock()
+	ewma, ok := l.ewma, !l.last.IsZero()
+	l.mu.Unlock()
+	return ewma * float64(atomic.LoadInt64(&l.inflight)+1), ok
+}
+
+// loadTracker keeps the load of every server, it is shared by load aware selectors
+type loadTracker struct {
+	mu    sync.Mutex // protect loads
+	loads map[string]*nodeLoad
+}
+
+func (t *loadTracker) load(rpcAddr string) *nodeLoad {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.loads == nil {
+		t.loads = make(map[string]*nodeLoad)
+	}
+	l, ok := t.loads[rpcAddr]
+	if !ok {
+		l = new(nodeLoad)
+		t.loads[rpcAddr] = l
+	}
+	return l
+}
+
+func (t *loadTracker) Start(_ context.Context, rpcAddr, _ string) {
+	atomic.AddInt64(&t.load(rpcAddr).inflight, 1)
+}
+
+func (t *loadTracker) Done(ctx context.Context, rpcAddr, _ string, latency time.Duration, err error) {
+	l := t.load(rpcAddr)
+	atomic.AddInt64(&l.inflight, -1)
+	if err != nil && errors.Is(ctx.Err(), context.Canceled) {
+		return // canceled by the caller, e.g. the loser of a hedge, its latency is cut short
+	}
+	l.observe(latency, err != nil)
+}
+
+// InFlight returns the number of outstanding calls of every server that has been called
+func (t *loadTracker) InFlight() map[string]int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	inflight := make(map[string]int64, len(t.loads))
+	for rpcAddr, l := range t.loads {
+		inflight[rpcAddr] = atomic.LoadInt64(&l.inflight)
+	}
+	return inflight
+}
+
+// p2cSelector picks two random servers and selects the less loaded one
+type p2cSelector struct {
+	loadTracker
+}
+
+func newP2CSelector() *p2cSelector {
+	return new(p2cSelector)
+}
+
+func (s *p2cSelector) Select(_ context.Context, _ string, _ interface{}, servers []string) (string, error) {
+	if len(servers) == 1 {
+		return servers[0], nil
+	}
+	i := rand.Intn(len(servers))
+	j := rand.Intn(len(servers) - 1)
+	if j >= i {
+		j++
+	}
+	a, b := servers[i], servers[j]
+	la, lb := s.load(a), s.load(b)
+	scoreA, okA := la.score()
+	scoreB, okB := lb.score()
+	if !okA || !okB {
+		// without the latency of both, the one with fewer in-flight calls is less loaded,
+		// otherwise a burst would all go to a new server before its first call completes.
+		// On a tie the one without latency is tried, so that it gets a sample.
+		inflightA, inflightB := atomic.LoadInt64(&la.inflight), atomic.LoadInt64(&lb.inflight)
+		if inflightB < inflightA || (inflightB == inflightA && !okB) {
+			return b, nil
+		}
+		return a, nil
+	}
+	if scoreB < scoreA {
+		return b, nil
+	}
+	return a, nil
+}
@@ -0,0 +1,39 @@
+package xclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"geeRPC/client"
+	"geeRPC/service"
+	"strings"
+	"time"
+)
+
+// ProbeHealth calls Health.Check on the server at addr, the server must be SERVING.
+// A server without the Health service is only checked for answering RPCs.
+// It is the registry.Prober of geeRPC servers, e.g. registry.WithHealthProbe(xclient.ProbeHealth, ...).
+func ProbeHealth(addr string, timeout time.Duration) error {
+	opt := *service.DefaultOption
+	opt.ConnectTimeout = timeout
+	c, err := client.XDial(addr, &opt)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = c.Close() }()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	var reply service.HealthCheckResponse
+	err = c.Call(ctx, "Health.Check", &service.HealthCheckRequest{}, &reply)
+	var serverErr client.ServerError
+	if errors.As(err, &serverErr) && strings.HasPrefix(string(serverErr), "rpc server: can't find service") {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if reply.Status != service.StatusServing {
+		return fmt.Errorf("rpc client: server %s is %s", addr, reply.Status)
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package xclient
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Selector decides which server a call goes to, Discovery only tells which servers exist.
+type Selector interface {
+	// Select returns one of servers for the call with args, servers is never empty.
+	// Servers excluded from the call, e.g. those already tried or with an open circuit, are not in servers.
+	Select(ctx context.Context, serviceMethod string, args interface{}, servers []string) (string, error)
+	// Done is called when a call to rpcAddr completes.
+	Done(ctx context.Context, rpcAddr, serviceMethod string, latency time.Duration, err error)
+}
+
+// CallTracker is implemented by Selectors that also need to know when calls start,
+// every Start is followed by a Done of the same call.
+type CallTracker interface {
+	Start(ctx context.Context, rpcAddr, serviceMethod string)
+}
+
+// ServerTracker is implemented by Selectors that keep state of every server, e.g. a hash ring.
+// UpdateServers is called with all the servers in discovery before every selection,
+// including the servers excluded from the call.
+type ServerTracker interface {
+	UpdateServers(servers []string)
+}
+
+var errUnsupportedMode = errors.New("rpc discovery: not supported select mode")
+
+// NewSelector returns the built-in Selector of mode,
+// WeightedRoundRobinSelect reads the weights of servers from d if it has a Weight(addr) method.
+func NewSelector(mode SelectMode, d Discovery) (Selector, error) {
+	switch mode {
+	case RandomSelect:
+		return newRandomSelector(), nil
+	case RoundRobinSelect:
+		return newRoundRobinSelector(), nil
+	case WeightedRoundRobinSelect:
+		return newWeightedRoundRobinSelector(d), nil
+	case ConsistentHashSelect:
+		return NewConsistentHashSelector(defaultVirtualNodes, nil), nil
+	case P2CSelect:
+		return newP2CSelector(), nil
+	case LeastActiveSelect:
+		return newLeastActiveSelector(), nil
+	default:
+		return nil, errUnsupportedMode
+	}
+}
+
+// noFeedback is embedded by selectors that don't care about call results
+type noFeedback struct{}
+
+func (noFeedback) Done(context.Context, string, string, time.Duration, error) {}
+
+type randomSelector struct {
+	noFeedback
+	mu sync.Mutex // protect r
+	r  *rand.Rand // generate random number
+}
+
+func newRandomSelector() *randomSelector {
+	return &randomSelector{r: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+func (s *randomSelector) Select(_ context.Context, _ string, _ interface{}, servers []string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return servers[s.r.Intn(len(servers))], nil
+}
+
+type roundRobinSelector struct {
+	noFeedback
+	mu    sync.Mutex
+	index int // record the selected position for robin algorithm
+}
+
+func newRoundRobinSelector() *roundRobinSelector {
+	return &roundRobinSelector{index: rand.Intn(math.MaxInt32 - 1)}
+}
+
+func (s *roundRobinSelector) Select(_ context.Context, _ string, _ interface{}, servers []string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := len(servers)
+	server := servers[s.index%n] // servers could be updated, so mode n to ensure safety
+	s.index = (s.index + 1) % n
+	return server, nil
+}
+
+// weigher is implemented by discoveries that know the weight of servers
+type weigher interface {
+	Weight(addr string) int
+}
+
+// weightedRoundRobinSelector is smooth weighted round robin, the same as nginx:
+// every server gains its weight, the one with the highest current weight is selected
+// and loses the total weight.
+type weightedRoundRobinSelector struct {
+	noFeedback
+	weigher weigher        // nil means every server weighs 1
+	mu      sync.Mutex     // protect current
+	current map[string]int // current weight of servers
+}
+
+func newWeightedRoundRobinSelector(d Discovery) *weightedRoundRobinSelector {
+	w, _ := d.(weigher)
+	return &weightedRoundRobinSelector{weigher: w, current: make(map[string]int)}
+}
+
+func (s *weightedRoundRobinSelector) Select(_ context.Context, _ string, _ interface{}, servers []string) (string, error) {
+	weights := make([]int, len(servers))
+	for i, server := range servers {
+		weights[i] = 1
+		if s.weigher != nil {
+			if w := s.weigher.Weight(server); w > 0 {
+				weights[i] = w
+			}
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	best, total := -1, 0
+	for i, server := range servers {
+		s.current[server] += weights[i]
+		total += weights[i]
+		if best < 0 || s.current[server] > s.current[servers[best]] {
+			best = i
+		}
+	}
+	s.current[servers[best]] -= total
+	return servers[best], nil
+}
@@ -2,24 +2,114 @@ package xclient
 
 import (
 	"context"
-	. "geerpc"
+	"errors"
+	. "geeRPC/client"
+	"geeRPC/service"
 	"io"
+	"log"
 	"reflect"
 	"sync"
+	"time"
 )
 
 type XClient struct {
-	d       Discovery
-	mode    SelectMode
-	opt     *Option
-	mu      sync.Mutex // protect following
-	clients map[string]*Client
+	d        Discovery
+	mode     SelectMode
+	opt      *service.Option
+	retry    *RetryPolicy // used by Failover and Failtry, nil means DefaultRetryPolicy
+	failMode FailMode     // Failfast by default, i.e. every call is attempted only once
+	modeSet  bool         // failMode is set explicitly
+	forks    int          // number of servers called in Fork mode, 0 means all
+	breaker  *BreakerConfig
+	outlier  *outlierDetector
+	hedging  *hedging // nil means no method is hedged
+	selector Selector
+	hashKey  HashKeyFunc // hash key of ConsistentHashSelect
+	replicas int         // virtual nodes of ConsistentHashSelect
+	mu       sync.Mutex  // protect following
+	clients  map[string]*Client
+	breakers map[string]*circuitBreaker
 }
 
 var _ io.Closer = (*XClient)(nil)
+var _ Caller = (*XClient)(nil)
 
-func NewXClient(d Discovery, mode SelectMode, opt *Option) *XClient {
-	return &XClient{d: d, mode: mode, opt: opt, clients: make(map[string]*Client)}
+// XClientOption configures optional behaviours of XClient
+type XClientOption func(xc *XClient)
+
+// WithRetryPolicy decides how Failover and Failtry retry failed calls,
+// DefaultRetryPolicy is used if it isn't set.
+// Unless WithFailMode is also given, in any order, it changes the default FailMode
+// from Failfast to Failover, so that setting a retry policy alone makes calls retried
+// on other servers. Calls made in Failfast or Fork mode are never retried.
+func WithRetryPolicy(p *RetryPolicy) XClientOption {
+	return func(xc *XClient) {
+		xc.retry = p
+		if !xc.modeSet {
+			xc.failMode = Failover
+		}
+	}
+}
+
+// WithFailMode sets the default FailMode of calls, it can be overridden by ContextWithFailMode.
+// Without it the default is Failfast, or Failover if WithRetryPolicy is given.
+func WithFailMode(mode FailMode) XClientOption {
+	return func(xc *XClient) {
+		xc.failMode = mode
+		xc.modeSet = true
+	}
+}
+
+// WithForkCount sets how many servers are called concurrently in Fork mode, n <= 0 means all servers
+func WithForkCount(n int) XClientOption {
+	return func(xc *XClient) {
+		xc.forks = n
+	}
+}
+
+// WithCircuitBreaker keeps a circuit breaker for every server address,
+// Call skips servers whose circuit is open.
+func WithCircuitBreaker(cfg BreakerConfig) XClientOption {
+	return func(xc *XClient) {
+		xc.breaker = &cfg
+	}
+}
+
+// WithSelector makes XClient select servers by s instead of the built-in Selector of the SelectMode
+func WithSelector(s Selector) XClientOption {
+	return func(xc *XClient) {
+		xc.selector = s
+	}
+}
+
+// NewXClient returns an XClient selecting servers of d by mode, it panics if mode is not
+// supported and no Selector is given by WithSelector.
+func NewXClient(d Discovery, mode SelectMode, opt *service.Option, opts ...XClientOption) *XClient {
+	xc := &XClient{
+		d:        d,
+		mode:     mode,
+		opt:      opt,
+		clients:  make(map[string]*Client),
+		breakers: make(map[string]*circuitBreaker),
+	}
+	for _, o := range opts {
+		o(xc)
+	}
+	if xc.selector == nil {
+		xc.selector = xc.newSelector()
+	}
+	return xc
+}
+
+func (xc *XClient) newSelector() Selector {
+	if xc.mode == ConsistentHashSelect {
+		return NewConsistentHashSelector(xc.replicas, xc.hashKey)
+	}
+	s, err := NewSelector(xc.mode, xc.d)
+	if err != nil {
+		log.Panicf("rpc xclient: %v %d, use WithSelector for custom selection", err, xc.mode)
+	}
+	return s
 }
 
 func (xc *XClient) Close() error {
@@ -54,22 +144,171 @@ func (xc *XClient) dial(rpcAddr string) (*Client, error) {
 }
 
 func (xc *XClient) call(rpcAddr string, ctx context.Context, serviceMethod string, args, reply interface{}) error {
+	if t, ok := xc.selector.(CallTracker); ok {
+		t.Start(ctx, rpcAddr, serviceMethod)
+	}
+	start := time.Now()
 	client, err := xc.dial(rpcAddr)
+	if err == nil {
+		err = client.Call(ctx, serviceMethod, args, reply)
+	}
+	xc.selector.Done(ctx, rpcAddr, serviceMethod, time.Since(start), err)
+	xc.report(ctx, rpcAddr, err)
+	return err
+}
+
+// report feeds the result of a call to rpcAddr back to the circuit breaker and the outlier detector
+func (xc *XClient) report(ctx context.Context, rpcAddr string, err error) {
+	// canceled by the caller, it says nothing about the server, but a timeout does
+	canceled := err != nil && errors.Is(ctx.Err(), context.Canceled)
+	if xc.outlier != nil && !canceled {
+		xc.outlier.onResult(rpcAddr, serverFailed(err))
+	}
+	if xc.breaker == nil {
+		return
+	}
+	cb := xc.circuitBreaker(rpcAddr)
+	if canceled {
+		cb.release()
+		return
+	}
+	cb.onResult(serverFailed(err))
+}
+
+// serverFailed reports whether err is a failure of the server: an error of the connection or a timeout.
+// A ServerError is returned by the method, the server itself works.
+func serverFailed(err error) bool {
+	var serverErr ServerError
+	return err != nil && !errors.As(err, &serverErr)
+}
+
+// pruneBreakers drops the circuit breakers of servers that are no longer in discovery
+func (xc *XClient) pruneBreakers(servers []string) {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	if len(xc.breakers) == 0 {
+		return
+	}
+	alive := make(map[string]bool, len(servers))
+	for _, rpcAddr := range servers {
+		alive[rpcAddr] = true
+	}
+	for rpcAddr := range xc.breakers {
+		if !alive[rpcAddr] {
+			delete(xc.breakers, rpcAddr)
+		}
+	}
+}
+
+func (xc *XClient) circuitBreaker(rpcAddr string) *circuitBreaker {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	cb, ok := xc.breakers[rpcAddr]
+	if !ok {
+		cb = newCircuitBreaker(rpcAddr, xc.breaker)
+		xc.breakers[rpcAddr] = cb
+	}
+	return cb
+}
+
+// BreakerStats returns a snapshot of the circuit breaker of every server that has been called
+func (xc *XClient) BreakerStats() map[string]BreakerStats {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	stats := make(map[string]BreakerStats, len(xc.breakers))
+	for rpcAddr, cb := range xc.breakers {
+		stats[rpcAddr] = cb.stats()
+	}
+	return stats
+}
+
+// pick asks the Selector for a server, servers in exclude, ejected servers
+// and servers whose circuit is open are not offered
+func (xc *XClient) pick(ctx context.Context, serviceMethod string, args interface{}, exclude map[string]bool) (string, error) {
+	servers, err := xc.d.GetAll()
 	if err != nil {
-		return err
+		return "", err
+	}
+	if len(servers) == 0 {
+		return "", errNoServers
+	}
+	if xc.breaker != nil {
+		xc.pruneBreakers(servers)
+	}
+	if t, ok := xc.selector.(ServerTracker); ok {
+		t.UpdateServers(servers)
+	}
+	rejected := false
+	candidates := make([]string, 0, len(servers))
+	for _, rpcAddr := range servers {
+		if exclude[rpcAddr] || (xc.outlier != nil && xc.outlier.ejected(rpcAddr)) {
+			continue
+		}
+		if xc.breaker != nil && !xc.circuitBreaker(rpcAddr).ready() {
+			rejected = true
+			continue
+		}
+		candidates = append(candidates, rpcAddr)
 	}
-	return client.Call(ctx, serviceMethod, args, reply)
+	for len(candidates) > 0 {
+		rpcAddr, err := xc.selector.Select(ctx, serviceMethod, args, candidates)
+		if err != nil {
+			return "", err
+		}
+		if xc.breaker == nil || xc.circuitBreaker(rpcAddr).allow() {
+			return rpcAddr, nil
+		}
+		// the circuit has changed since ready, offer the rest
+		rejected = true
+		for i, s := range candidates {
+			if s == rpcAddr {
+				candidates = append(candidates[:i], candidates[i+1:]...)
+				break
+			}
+		}
+	}
+	if rejected {
+		return "", ErrCircuitOpen
+	}
+	return "", errAllExcluded
 }
 
 // Call invokes the named function, waits for it to complete,
 // and returns its error status.
-// xc will choose a proper server.
+// xc will choose a proper server, what happens on failure depends on the FailMode.
 func (xc *XClient) Call(ctx context.Context, serviceMethod string, args, reply interface{}) error {
-	rpcAddr, err := xc.d.Get(xc.mode)
-	if err != nil {
-		return err
+	mode := xc.failModeOf(ctx)
+	switch mode {
+	case Failfast:
+		rpcAddr, err := xc.pick(ctx, serviceMethod, args, nil)
+		if err != nil {
+			return err
+		}
+		return xc.invoke(rpcAddr, ctx, serviceMethod, args, reply)
+	case Fork:
+		return xc.fork(ctx, serviceMethod, args, reply)
 	}
-	return xc.call(rpcAddr, ctx, serviceMethod, args, reply)
+	policy := xc.retry
+	if policy == nil {
+		policy = DefaultRetryPolicy
+	}
+	var rpcAddr string
+	tried := make(map[string]bool)
+	return policy.Do(ctx, serviceMethod, func(int) error {
+		if mode == Failover || rpcAddr == "" {
+			addr, err := xc.pick(ctx, serviceMethod, args, tried)
+			if err == errAllExcluded {
+				// every server has been tried, start over
+				addr, err = xc.pick(ctx, serviceMethod, args, nil)
+			}
+			if err != nil {
+				return err
+			}
+			rpcAddr = addr
+		}
+		tried[rpcAddr] = true
+		return xc.invoke(rpcAddr, ctx, serviceMethod, args, reply)
+	})
 }
 
 // Broadcast invokes the named function for every server registered in discovery
@@ -83,14 +322,12 @@ func (xc *XClient) Broadcast(ctx context.Context, serviceMethod string, args, re
 	var e error
 	replyDone := reply == nil // if reply is nil, don't need to set value
 	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 	for _, rpcAddr := range servers {
 		wg.Add(1)
 		go func(rpcAddr string) {
 			defer wg.Done()
-			var clonedReply interface{}
-			if reply != nil {
-				clonedReply = reflect.New(reflect.ValueOf(reply).Elem().Type()).Interface()
-			}
+			clonedReply := cloneReply(reply)
 			err := xc.call(rpcAddr, ctx, serviceMethod, args, clonedReply)
 			mu.Lock()
 			if err != nil && e == nil {
@@ -98,7 +335,7 @@ func (xc *XClient) Broadcast(ctx context.Context, serviceMethod string, args, re
 				cancel() // if any call failed, cancel unfinished calls
 			}
 			if err == nil && !replyDone {
-				reflect.ValueOf(reply).Elem().Set(reflect.ValueOf(clonedReply).Elem())
+				setReply(reply, clonedReply)
 				replyDone = true
 			}
 			mu.Unlock()
@@ -107,3 +344,16 @@ func (xc *XClient) Broadcast(ctx context.Context, serviceMethod string, args, re
 	wg.Wait()
 	return e
 }
+
+// cloneReply returns a new value of the type reply points to, or nil if reply is nil
+func cloneReply(reply interface{}) interface{} {
+	if reply == nil {
+		return nil
+	}
+	return reflect.New(reflect.ValueOf(reply).Elem().Type()).Interface()
+}
+
+// setReply copies the value cloned points to into reply
+func setReply(reply, cloned interface{}) {
+	reflect.ValueOf(reply).Elem().Set(reflect.ValueOf(cloned).Elem())
+}
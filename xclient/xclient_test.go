@@ -0,0 +1,793 @@
+package xclient
+
+import (
+	"context"
+	"errors"
+	"geeRPC/client"
+	"geeRPC/registry"
+	"geeRPC/service"
+	"net"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+type Foo int
+
+type Args struct{ Num1, Num2 int }
+
+func (f Foo) Sum(args Args, reply *int) error {
+	*reply = args.Num1 + args.Num2
+	return nil
+}
+
+// Sleep sleeps f milliseconds before replying
+func (f Foo) Sleep(args Args, reply *int) error {
+	time.Sleep(time.Millisecond * time.Duration(f))
+	*reply = args.Num1 + args.Num2
+	return nil
+}
+
+// Fail returns an error of the method, the server works
+func (f Foo) Fail(args Args, reply *int) error {
+	return errors.New("invalid argument")
+}
+
+func startServer(t *testing.T) string {
+	return startSlowServer(t, 0)
+}
+
+func startSlowServer(t *testing.T, delayMs int) string {
+	foo := Foo(delayMs)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("failed to listen:", err)
+	}
+	server := service.NewServer()
+	_ = server.Register(&foo)
+	go server.Accept(l)
+	return "tcp@" + l.Addr().String()
+}
+
+// Blocker is a Foo whose Sleep doesn't return until the test ends
+type Blocker struct{ release chan struct{} }
+
+func (b *Blocker) Sleep(args Args, reply *int) error {
+	<-b.release
+	*reply = args.Num1 + args.Num2
+	return nil
+}
+
+func startBlockingServer(t *testing.T) string {
+	b := &Blocker{release: make(chan struct{})}
+	t.Cleanup(func() { close(b.release) })
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("failed to listen:", err)
+	}
+	server := service.NewServer()
+	_ = server.RegisterName("Foo", b)
+	go server.Accept(l)
+	return "tcp@" + l.Addr().String()
+}
+
+// deadAddr is an address nobody listens on
+func deadAddr(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("failed to listen:", err)
+	}
+	_ = l.Close()
+	return "tcp@" + l.Addr().String()
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	cfg := BreakerConfig{FailureRatio: 0.5, MinRequests: 4, CoolDown: time.Millisecond * 20, HalfOpenProbes: 1}
+	cb := newCircuitBreaker("tcp@127.0.0.1:9999", &cfg)
+	for i := 0; i < 4; i++ {
+		cb.onResult(i%2 == 0)
+	}
+	if cb.stats().State != StateOpen || cb.allow() {
+		t.Fatal("circuit should be open after half of the calls failed")
+	}
+	time.Sleep(cfg.CoolDown)
+	if !cb.allow() || cb.stats().State != StateHalfOpen {
+		t.Fatal("circuit should let a probe through after cool-down")
+	}
+	if cb.allow() {
+		t.Fatal("only one probe is allowed in half-open state")
+	}
+	cb.onResult(false)
+	if cb.stats().State != StateClosed {
+		t.Fatal("circuit should be closed after a successful probe")
+	}
+}
+
+func TestXClient_CircuitBreakerTimeout(t *testing.T) {
+	slow := startSlowServer(t, 200)
+	d := NewMultiServerDiscovery([]string{slow})
+	cfg := BreakerConfig{FailureRatio: 0.5, MinRequests: 2, CoolDown: time.Minute}
+	xc := NewXClient(d, RandomSelect, nil, WithCircuitBreaker(cfg))
+	defer func() { _ = xc.Close() }()
+	for i := 0; i < 2; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*20)
+		var reply int
+		err := xc.Call(ctx, "Foo.Sleep", &Args{Num1: i, Num2: i}, &reply)
+		cancel()
+		if err == nil {
+			t.Fatal("expect the call to time out")
+		}
+	}
+	if state := xc.BreakerStats()[slow].State; state != StateOpen {
+		t.Fatalf("expect timeouts to open the circuit, got %s", state)
+	}
+	var reply int
+	if err := xc.Call(context.Background(), "Foo.Sleep", &Args{}, &reply); err != ErrCircuitOpen {
+		t.Fatalf("expect ErrCircuitOpen, got %v", err)
+	}
+
+	// a call canceled by the caller says nothing about the server
+	xc2 := NewXClient(d, RandomSelect, nil, WithCircuitBreaker(cfg))
+	defer func() { _ = xc2.Close() }()
+	for i := 0; i < 2; i++ {
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(time.Millisecond*20, cancel)
+		var reply int
+		_ = xc2.Call(ctx, "Foo.Sleep", &Args{Num1: i, Num2: i}, &reply)
+	}
+	if state := xc2.BreakerStats()[slow].State; state != StateClosed {
+		t.Fatalf("expect canceled calls not to open the circuit, got %s", state)
+	}
+
+	_ = d.Update([]string{startServer(t)})
+	_ = xc.Call(context.Background(), "Foo.Sum", &Args{}, &reply)
+	if _, ok := xc.BreakerStats()[slow]; ok {
+		t.Fatal("expect the breaker of a removed server to be dropped")
+	}
+}
+
+func TestXClient_CircuitBreakerServerError(t *testing.T) {
+	addr := startServer(t)
+	d := NewMultiServerDiscovery([]string{addr})
+	cfg := BreakerConfig{FailureRatio: 0.5, MinRequests: 2, CoolDown: time.Minute}
+	xc := NewXClient(d, RandomSelect, nil, WithCircuitBreaker(cfg))
+	defer func() { _ = xc.Close() }()
+	for i := 0; i < 5; i++ {
+		var reply int
+		if err := xc.Call(context.Background(), "Foo.Fail", &Args{}, &reply); err == nil || err == ErrCircuitOpen {
+			t.Fatalf("expect the error of the method, got %v", err)
+		}
+	}
+	if stats := xc.BreakerStats()[addr]; stats.State != StateClosed || stats.Failures != 0 {
+		t.Fatalf("expect errors of the method not to count as failures, got %+v", stats)
+	}
+}
+
+func TestXClient_Failover(t *testing.T) {
+	d := NewMultiServerDiscovery([]string{deadAddr(t), startServer(t)})
+	policy := &client.RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond}
+	for _, mode := range []SelectMode{RandomSelect, RoundRobinSelect} {
+		xc := NewXClient(d, mode, nil, WithRetryPolicy(policy))
+		for i := 0; i < 5; i++ {
+			var reply int
+			if err := xc.Call(context.Background(), "Foo.Sum", &Args{Num1: i, Num2: i}, &reply); err != nil || reply != 2*i {
+				t.Fatalf("failover call failed: reply %d, err %v", reply, err)
+			}
+		}
+		_ = xc.Close()
+	}
+}
+
+// firstSelector selects the first server offered and records the servers called
+type firstSelector struct {
+	mu     sync.Mutex
+	called []string
+}
+
+func (s *firstSelector) Select(_ context.Context, _ string, _ interface{}, servers []string) (string, error) {
+	return servers[0], nil
+}
+
+func (s *firstSelector) Done(_ context.Context, rpcAddr, _ string, _ time.Duration, _ error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.called = append(s.called, rpcAddr)
+}
+
+func TestXClient_RetryOtherServer(t *testing.T) {
+	dead, live := deadAddr(t), startServer(t)
+	d := NewMultiServerDiscovery([]string{dead, live})
+	s := new(firstSelector)
+	policy := &client.RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond}
+	xc := NewXClient(d, RandomSelect, nil, WithFailMode(Failover), WithRetryPolicy(policy), WithSelector(s))
+	defer func() { _ = xc.Close() }()
+	var reply int
+	if err := xc.Call(context.Background(), "Foo.Sum", &Args{Num1: 1, Num2: 2}, &reply); err != nil || reply != 3 {
+		t.Fatalf("retried call failed: reply %d, err %v", reply, err)
+	}
+	if len(s.called) != 2 || s.called[0] != dead || s.called[1] != live {
+		t.Fatalf("expect the retry to select the other server, called %v", s.called)
+	}
+}
+
+func TestXClient_FailModes(t *testing.T) {
+	dead, live := deadAddr(t), startServer(t)
+	d := NewMultiServerDiscovery([]string{dead, live})
+	policy := &client.RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond}
+	call := func(ctx context.Context, opts ...XClientOption) ([]string, error) {
+		s := new(firstSelector)
+		xc := NewXClient(d, RandomSelect, nil, append(opts, WithSelector(s))...)
+		defer func() { _ = xc.Close() }()
+		var reply int
+		err := xc.Call(ctx, "Foo.Sum", &Args{Num1: 1, Num2: 2}, &reply)
+		return s.called, err
+	}
+
+	if called, err := call(context.Background()); err == nil || len(called) != 1 {
+		t.Fatalf("expect Failfast by default, called %v, err %v", called, err)
+	}
+	if called, err := call(context.Background(), WithRetryPolicy(policy), WithFailMode(Failfast)); err == nil || len(called) != 1 {
+		t.Fatalf("expect WithFailMode to take precedence over WithRetryPolicy, called %v, err %v", called, err)
+	}
+	called, err := call(context.Background(), WithFailMode(Failtry), WithRetryPolicy(policy))
+	if err == nil || len(called) != 3 || called[1] != dead || called[2] != dead {
+		t.Fatalf("expect Failtry to retry the same server, called %v, err %v", called, err)
+	}
+	called, err = call(ContextWithFailMode(context.Background(), Failover))
+	if err != nil || len(called) != 2 || called[1] != live {
+		t.Fatalf("expect ContextWithFailMode to override the default, called %v, err %v", called, err)
+	}
+	called, err = call(context.Background(), WithFailMode(Fork), WithForkCount(1))
+	if err == nil || len(called) != 1 || called[0] != dead {
+		t.Fatalf("expect Fork to call only 1 server, called %v, err %v", called, err)
+	}
+}
+
+func TestXClient_Fork(t *testing.T) {
+	slow := startSlowServer(t, 1000)
+	d := NewMultiServerDiscovery([]string{deadAddr(t), slow, startServer(t)})
+	xc := NewXClient(d, RandomSelect, nil, WithFailMode(Fork))
+	defer func() { _ = xc.Close() }()
+	start := time.Now()
+	var reply int
+	if err := xc.Call(context.Background(), "Foo.Sleep", &Args{Num1: 1, Num2: 2}, &reply); err != nil || reply != 3 {
+		t.Fatalf("fork call failed: reply %d, err %v", reply, err)
+	}
+	if time.Since(start) > time.Millisecond*500 {
+		t.Fatal("fork call should return with the first success")
+	}
+}
+
+func TestXClient_Hedging(t *testing.T) {
+	d := NewMultiServerDiscovery([]string{startBlockingServer(t), startServer(t)})
+	// the blocking server is always selected first
+	xc := NewXClient(d, RandomSelect, nil, WithSelector(new(firstSelector)), WithHedging("Foo.Sleep", time.Millisecond*20))
+	defer func() { _ = xc.Close() }()
+	for i := 0; i < 4; i++ {
+		// without hedging, the calls would time out
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		var reply int
+		err := xc.Call(ctx, "Foo.Sleep", &Args{Num1: i, Num2: i}, &reply)
+		cancel()
+		if err != nil || reply != 2*i {
+			t.Fatalf("hedged call failed: reply %d, err %v", reply, err)
+		}
+	}
+	if stats := xc.HedgeStats(); stats.Hedged != 4 || stats.Won != 4 {
+		t.Fatalf("expect every call to be won by the hedged request, got %+v", stats)
+	}
+}
+
+func TestHedgePolicy_Percentile(t *testing.T) {
+	p := &hedgePolicy{delay: time.Second, percentile: 0.95}
+	for i := 1; i < hedgeMinSamples; i++ {
+		p.observe(time.Millisecond)
+	}
+	if d := p.hedgeDelay(); d != time.Second {
+		t.Fatalf("expect the fallback delay before enough samples, got %s", d)
+	}
+	for i := 1; i <= hedgeWindow; i++ {
+		p.observe(time.Millisecond * time.Duration(i))
+	}
+	if d := p.hedgeDelay(); d != time.Millisecond*95 {
+		t.Fatalf("expect p95 of the last %d latencies to be 95ms, got %s", hedgeWindow, d)
+	}
+}
+
+func TestMultiServersDiscovery_WeightedRoundRobin(t *testing.T) {
+	d := NewMultiServerDiscovery([]string{"tcp@a?weight=5", "tcp@b", "tcp@c?weight=1"})
+	count := make(map[string]int)
+	var seq []string
+	for i := 0; i < 7; i++ {
+		addr, _ := d.Get(WeightedRoundRobinSelect)
+		count[addr]++
+		seq = append(seq, addr)
+	}
+	if count["tcp@a"] != 5 || count["tcp@b"] != 1 || count["tcp@c"] != 1 {
+		t.Fatalf("unexpected distribution %v", count)
+	}
+	if seq[0] != "tcp@a" || seq[1] != "tcp@a" || seq[2] == "tcp@a" {
+		t.Fatalf("selection isn't smooth: %v", seq)
+	}
+}
+
+func TestMultiServersDiscovery_DuplicateServers(t *testing.T) {
+	d := NewMultiServerDiscovery([]string{"tcp@a?weight=2", "tcp@b", "tcp@a?weight=3"})
+	if servers, _ := d.GetAll(); len(servers) != 2 || d.Weight("tcp@a") != 5 {
+		t.Fatalf("expect tcp@a once with weight 5, got %v and weight %d", servers, d.Weight("tcp@a"))
+	}
+	count := make(map[string]int)
+	for i := 0; i < 12; i++ {
+		addr, _ := d.Get(WeightedRoundRobinSelect)
+		count[addr]++
+	}
+	if count["tcp@a"] != 10 || count["tcp@b"] != 2 {
+		t.Fatalf("unexpected distribution %v", count)
+	}
+}
+
+func TestHashRing_Rebalance(t *testing.T) {
+	r := newHashRing(defaultVirtualNodes)
+	servers := []string{"tcp@a", "tcp@b", "tcp@c"}
+	before := make(map[string]string)
+	for i := 0; i < 1000; i++ {
+		key := strconv.Itoa(i)
+		before[key] = r.get(servers, key)
+	}
+	servers = append(servers, "tcp@d")
+	moved := 0
+	for key, s := range before {
+		if now := r.get(servers, key); now != s {
+			if now != "tcp@d" {
+				t.Fatalf("key %s moved from %s to %s, expect only moves to the new server", key, s, now)
+			}
+			moved++
+		}
+	}
+	if moved == 0 || moved > 500 {
+		t.Fatalf("unexpected number of moved keys: %d", moved)
+	}
+	if s := r.get(servers[:3], "42"); s != before["42"] {
+		t.Fatal("keys should go back after the server is removed")
+	}
+}
+
+func TestHashRing_Exclude(t *testing.T) {
+	r := newHashRing(defaultVirtualNodes)
+	servers := []string{"tcp@a", "tcp@b", "tcp@c"}
+	r.sync(servers)
+	keys := append([]uint32(nil), r.keys...)
+	for i := 0; i < 100; i++ {
+		key := strconv.Itoa(i)
+		owner := r.get(servers, key)
+		var rest []string
+		for _, s := range servers {
+			if s != owner {
+				rest = append(rest, s)
+			}
+		}
+		next := r.get(rest, key)
+		if next == owner || next == "" {
+			t.Fatalf("expect key %s to skip the excluded %s, got %q", key, owner, next)
+		}
+		if again := r.get(servers, key); again != owner {
+			t.Fatalf("excluding a server shouldn't change the owner of key %s: %s -> %s", key, owner, again)
+		}
+	}
+	if len(r.keys) != len(keys) || len(r.members) != 3 {
+		t.Fatal("excluding servers shouldn't change the ring")
+	}
+}
+
+func TestXClient_ConsistentHash(t *testing.T) {
+	d := NewMultiServerDiscovery([]string{startServer(t), startServer(t), startServer(t)})
+	xc := NewXClient(d, ConsistentHashSelect, nil)
+	defer func() { _ = xc.Close() }()
+	n1, n2 := 7, 7
+	first, err := xc.pick(context.Background(), "Foo.Sum", &n1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		if rpcAddr, _ := xc.pick(context.Background(), "Foo.Sum", &n2, nil); rpcAddr != first {
+			t.Fatalf("expect calls with equal args to go to %s, got %s", first, rpcAddr)
+		}
+	}
+	if rpcAddr, _ := xc.pick(context.Background(), "Foo.Sum", &n1, map[string]bool{first: true}); rpcAddr == first {
+		t.Fatal("expect the tried server to be skipped")
+	}
+}
+
+func TestNewXClient_UnsupportedMode(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expect NewXClient to panic with an unsupported mode")
+		}
+	}()
+	NewXClient(NewMultiServerDiscovery(nil), SelectMode(100), nil)
+}
+
+func TestDefaultHashKey(t *testing.T) {
+	n1, n2 := 42, 42
+	s1, s2 := "key", "key"
+	if defaultHashKey("", &n1) != defaultHashKey("", &n2) || defaultHashKey("", &n1) != "42" {
+		t.Fatal("expect pointers to equal ints to have the same key")
+	}
+	if defaultHashKey("", &s1) != defaultHashKey("", &s2) {
+		t.Fatal("expect pointers to equal strings to have the same key")
+	}
+	if defaultHashKey("", &Args{Num1: 1}) != defaultHashKey("", Args{Num1: 1}) {
+		t.Fatal("expect args passed by pointer and by value to have the same key")
+	}
+	if defaultHashKey("", nil) != "" || defaultHashKey("", (*int)(nil)) != "<nil>" {
+		t.Fatal("expect nil args to have a fixed key")
+	}
+}
+
+func TestHashRing_Collision(t *testing.T) {
+	r := newHashRing(1)
+	// simulate a collision: tcp@x holds the slot of the only virtual node of tcp@b
+	h := r.hash("0tcp@b")
+	r.nodes[h] = "tcp@x"
+	r.members["tcp@x"] = true
+	r.sync([]string{"tcp@x", "tcp@b"})
+	if r.nodes[h] != "tcp@x" {
+		t.Fatal("a colliding virtual node shouldn't take the slot of another server")
+	}
+	r.sync([]string{"tcp@x"})
+	if r.nodes[h] != "tcp@x" {
+		t.Fatal("removing a server shouldn't remove the virtual nodes of another server")
+	}
+}
+
+func TestXClient_P2C(t *testing.T) {
+	d := NewMultiServerDiscovery([]string{startSlowServer(t, 50), startServer(t)})
+	xc := NewXClient(d, P2CSelect, nil)
+	defer func() { _ = xc.Close() }()
+	slow := 0
+	for i := 0; i < 20; i++ {
+		start := time.Now()
+		var reply int
+		if err := xc.Call(context.Background(), "Foo.Sleep", &Args{Num1: i, Num2: i}, &reply); err != nil {
+			t.Fatal("call failed:", err)
+		}
+		if time.Since(start) > time.Millisecond*40 {
+			slow++
+		}
+	}
+	if slow > 3 {
+		t.Fatalf("slow server should get little traffic, got %d of 20 calls", slow)
+	}
+}
+
+// burst sends n calls to Foo.Sleep one after another without waiting for them to complete,
+// each call is in flight before the next one is selected.
+func burst(t *testing.T, xc *XClient, n int) {
+	total := func() int64 {
+		var inflight int64
+		for _, n := range xc.InFlight() {
+			inflight += n
+		}
+		return inflight
+	}
+	base := total()
+	for i := 0; i < n; i++ {
+		go func(i int) {
+			var reply int
+			_ = xc.Call(context.Background(), "Foo.Sleep", &Args{Num1: i, Num2: i}, &reply)
+		}(i)
+		deadline := time.Now().Add(time.Second * 5)
+		for {
+			inflight := total()
+			if inflight == base+int64(i+1) {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("expect %d calls in flight, got %d", base+int64(i+1), inflight)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+}
+
+func TestLoadTracker_Canceled(t *testing.T) {
+	var lt loadTracker
+	ctx := context.Background()
+	lt.Start(ctx, "tcp@a", "Foo.Sum")
+	lt.Done(ctx, "tcp@a", "Foo.Sum", time.Millisecond*100, nil)
+	before, _ := lt.load("tcp@a").score()
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	lt.Start(canceled, "tcp@a", "Foo.Sum")
+	lt.Done(canceled, "tcp@a", "Foo.Sum", time.Millisecond, context.Canceled)
+	if after, _ := lt.load("tcp@a").score(); after != before {
+		t.Fatalf("expect a canceled call to leave the latency unchanged, got %v, was %v", after, before)
+	}
+
+	timedOut, cancel := context.WithTimeout(ctx, 0)
+	defer cancel()
+	lt.Start(timedOut, "tcp@a", "Foo.Sum")
+	lt.Done(timedOut, "tcp@a", "Foo.Sum", time.Millisecond, context.DeadlineExceeded)
+	if after, _ := lt.load("tcp@a").score(); after <= before {
+		t.Fatalf("expect a timeout to count as a slow call, got %v, was %v", after, before)
+	}
+}
+
+func TestXClient_P2CColdBurst(t *testing.T) {
+	a, b := startBlockingServer(t), startBlockingServer(t)
+	xc := NewXClient(NewMultiServerDiscovery([]string{a, b}), P2CSelect, nil)
+	defer func() { _ = xc.Close() }()
+	burst(t, xc, 10)
+	if inflight := xc.InFlight(); inflight[a] != 5 || inflight[b] != 5 {
+		t.Fatalf("expect a burst to be spread over the cold servers, got %v", inflight)
+	}
+}
+
+func TestXClient_LeastActive(t *testing.T) {
+	a, b := startBlockingServer(t), startBlockingServer(t)
+	xc := NewXClient(NewMultiServerDiscovery([]string{a, b}), LeastActiveSelect, nil)
+	defer func() { _ = xc.Close() }()
+	burst(t, xc, 4)
+	inflight := xc.InFlight()
+	if len(inflight) != 2 || inflight[a] != 2 || inflight[b] != 2 {
+		t.Fatalf("expect 2 in-flight calls on each server, got %v", inflight)
+	}
+
+	// with a server busier than the other, new calls go to the less loaded one
+	busy, idle := startBlockingServer(t), startBlockingServer(t)
+	xc2 := NewXClient(NewMultiServerDiscovery([]string{busy}), LeastActiveSelect, nil)
+	defer func() { _ = xc2.Close() }()
+	burst(t, xc2, 3)
+	_ = xc2.d.Update([]string{busy, idle})
+	burst(t, xc2, 2)
+	if inflight := xc2.InFlight(); inflight[busy] != 3 || inflight[idle] != 2 {
+		t.Fatalf("expect the new calls to go to the less loaded server, got %v", inflight)
+	}
+}
+
+type lastSelector struct{ done int }
+
+func (s *lastSelector) Select(_ context.Context, _ string, _ interface{}, servers []string) (string, error) {
+	return servers[len(servers)-1], nil
+}
+
+func (s *lastSelector) Done(context.Context, string, string, time.Duration, error) { s.done++ }
+
+func TestXClient_Selector(t *testing.T) {
+	d := NewMultiServerDiscovery([]string{deadAddr(t), startServer(t)})
+	s := new(lastSelector)
+	xc := NewXClient(d, RandomSelect, nil, WithSelector(s))
+	defer func() { _ = xc.Close() }()
+	for i := 0; i < 3; i++ {
+		var reply int
+		if err := xc.Call(context.Background(), "Foo.Sum", &Args{Num1: i, Num2: i}, &reply); err != nil {
+			t.Fatal("custom selector should always select the live server:", err)
+		}
+	}
+	if s.done != 3 {
+		t.Fatalf("expect 3 feedbacks, got %d", s.done)
+	}
+}
+
+func TestXClient_OutlierDetection(t *testing.T) {
+	dead := deadAddr(t)
+	d := NewMultiServerDiscovery([]string{dead, startServer(t), startServer(t)})
+	cfg := OutlierConfig{ConsecutiveErrors: 2, BaseEjectionTime: time.Minute, MaxEjectionPercent: 50}
+	xc := NewXClient(d, RoundRobinSelect, nil, WithOutlierDetection(cfg))
+	defer func() { _ = xc.Close() }()
+	failed := 0
+	for i := 0; i < 12; i++ {
+		var reply int
+		if err := xc.Call(context.Background(), "Foo.Sum", &Args{Num1: i, Num2: i}, &reply); err != nil {
+			failed++
+		}
+	}
+	if failed != 2 {
+		t.Fatalf("expect 2 failures before the dead server is ejected, got %d", failed)
+	}
+	if _, ok := xc.Ejected()[dead]; !ok || len(xc.Ejected()) != 1 {
+		t.Fatalf("expect only %s to be ejected, got %v", dead, xc.Ejected())
+	}
+}
+
+func TestXClient_OutlierDetectionServerError(t *testing.T) {
+	d := NewMultiServerDiscovery([]string{startServer(t), startServer(t)})
+	cfg := OutlierConfig{ConsecutiveErrors: 2, BaseEjectionTime: time.Minute, MaxEjectionPercent: 50}
+	xc := NewXClient(d, RoundRobinSelect, nil, WithOutlierDetection(cfg))
+	defer func() { _ = xc.Close() }()
+	for i := 0; i < 6; i++ {
+		var reply int
+		_ = xc.Call(context.Background(), "Foo.Fail", &Args{}, &reply)
+	}
+	if ejected := xc.Ejected(); len(ejected) != 0 {
+		t.Fatalf("expect errors of the method not to eject servers, got %v", ejected)
+	}
+}
+
+func TestOutlierDetector_RepeatedEjections(t *testing.T) {
+	servers := []string{"tcp@a", "tcp@b"}
+	cfg := OutlierConfig{ConsecutiveErrors: 1, BaseEjectionTime: time.Millisecond * 20, MaxEjectionPercent: 50}
+	o := newOutlierDetector(cfg, func() ([]string, error) { return servers, nil })
+	for i := 1; i <= 3; i++ {
+		o.onResult("tcp@a", true)
+		h := o.hosts["tcp@a"]
+		if !o.ejected("tcp@a") || h.ejections != i {
+			t.Fatalf("expect ejection %d, got %d", i, h.ejections)
+		}
+		if d := time.Until(h.ejectedUntil); d <= cfg.BaseEjectionTime*time.Duration(i-1) {
+			t.Fatalf("expect ejection %d to last about %s, got %s", i, cfg.BaseEjectionTime*time.Duration(i), d)
+		}
+		time.Sleep(time.Until(h.ejectedUntil))
+		// a success after the ejection doesn't forget the ejections without MaxEjectionTime
+		o.onResult("tcp@a", false)
+	}
+}
+
+func TestOutlierDetector_PoolSize(t *testing.T) {
+	servers := []string{"tcp@a", "tcp@b"}
+	cfg := OutlierConfig{ConsecutiveErrors: 1, BaseEjectionTime: time.Minute, MaxEjectionPercent: 50}
+	var o *outlierDetector
+	o = newOutlierDetector(cfg, func() ([]string, error) {
+		// discovery may make a request to the registry, it mustn't block the detector
+		if !o.mu.TryLock() {
+			t.Fatal("the servers shouldn't be got with o.mu held")
+		}
+		o.mu.Unlock()
+		return servers, nil
+	})
+	o.onResult("tcp@a", true)
+	o.onResult("tcp@b", true)
+	if !o.ejected("tcp@a") || o.ejected("tcp@b") {
+		t.Fatal("expect only 1 of 2 servers to be ejected")
+	}
+	servers = []string{"tcp@a", "tcp@b", "tcp@c", "tcp@d"}
+	o.onResult("tcp@b", true)
+	if !o.ejected("tcp@b") {
+		t.Fatal("expect the limit to follow the servers in discovery")
+	}
+	servers = []string{"tcp@c", "tcp@d"}
+	o.onResult("tcp@c", true)
+	if !o.ejected("tcp@c") {
+		t.Fatal("expect ejected servers that have left not to count")
+	}
+}
+
+func TestXClient_Quorum(t *testing.T) {
+	dead := deadAddr(t)
+	d := NewMultiServerDiscovery([]string{dead, startServer(t), startServer(t)})
+	xc := NewXClient(d, RandomSelect, nil)
+	defer func() { _ = xc.Close() }()
+	var reply int
+	results, err := xc.Gather(context.Background(), "Foo.Sum", &Args{Num1: 1, Num2: 2}, &reply)
+	if err != nil || len(results) != 3 || reply != 3 {
+		t.Fatalf("gather failed: %v, %v", results, err)
+	}
+	for rpcAddr, r := range results {
+		if (rpcAddr == dead) != (r.Error != nil) {
+			t.Fatalf("unexpected result of %s: %+v", rpcAddr, r)
+		}
+		if r.Error == nil && *r.Reply.(*int) != 3 {
+			t.Fatalf("unexpected reply of %s: %v", rpcAddr, r.Reply)
+		}
+	}
+	if _, err := xc.Quorum(context.Background(), "Foo.Sum", &Args{Num1: 1, Num2: 2}, &reply, 2); err != nil {
+		t.Fatal("quorum of 2 should be reached:", err)
+	}
+	if _, err := xc.Quorum(context.Background(), "Foo.Sum", &Args{Num1: 1, Num2: 2}, &reply, 3); err == nil {
+		t.Fatal("quorum of 3 shouldn't be reached")
+	}
+}
+
+func TestGeeRegistryWatchDiscovery(t *testing.T) {
+	ts := httptest.NewServer(registry.New(time.Minute))
+	defer ts.Close()
+	d := NewGeeRegistryWatchDiscovery(ts.URL, time.Second*5)
+	defer func() { _ = d.Close() }()
+	if servers, _ := d.GetAll(); len(servers) != 0 {
+		t.Fatalf("expect no servers, got %v", servers)
+	}
+	registry.Heartbeat(ts.URL, "tcp@127.0.0.1:9999", time.Minute)
+	deadline := time.Now().Add(time.Second)
+	for {
+		if servers, _ := d.GetAll(); len(servers) == 1 && servers[0] == "tcp@127.0.0.1:9999" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the new server should be pushed to the discovery at once")
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+}
+
+func TestGeeRegistryDiscovery_Filter(t *testing.T) {
+	ts := httptest.NewServer(registry.New(time.Minute))
+	defer ts.Close()
+	registry.HeartbeatItem(ts.URL, &registry.ServerItem{Addr: "tcp@127.0.0.1:9997", Tags: []string{"canary"}, Weight: 5}, time.Minute)
+	registry.Heartbeat(ts.URL, "tcp@127.0.0.1:9998", time.Minute)
+	d := NewGeeRegistryDiscovery(ts.URL, time.Minute)
+	d.SetFilter(registry.ServerFilter{Tags: []string{"canary"}})
+	if servers, _ := d.GetAll(); len(servers) != 1 || servers[0] != "tcp@127.0.0.1:9997" {
+		t.Fatalf("expect the canary server only, got %v", servers)
+	}
+	if w := d.Weight("tcp@127.0.0.1:9997"); w != 5 {
+		t.Fatalf("expect the weight from the registry to be 5, got %d", w)
+	}
+	d.SetFilter(registry.ServerFilter{})
+	if servers, _ := d.GetAll(); len(servers) != 2 {
+		t.Fatalf("expect the filter to take effect at once, got %v", servers)
+	}
+}
+
+func TestGeeRegistryDiscovery_Failover(t *testing.T) {
+	ts := httptest.NewServer(registry.New(time.Minute))
+	defer ts.Close()
+	dead := httptest.NewServer(nil)
+	dead.Close()
+	registries := dead.URL + "," + ts.URL
+	registry.Heartbeat(registries, "tcp@127.0.0.1:9999", time.Minute)
+
+	d := NewGeeRegistryDiscovery(registries, time.Minute)
+	if servers, err := d.GetAll(); err != nil || len(servers) != 1 {
+		t.Fatalf("expect the servers from the live registry, got %v %v", servers, err)
+	}
+	w := NewGeeRegistryWatchDiscovery(registries, time.Second*5)
+	defer func() { _ = w.Close() }()
+	deadline := time.Now().Add(time.Second * 3)
+	for {
+		if servers, _ := w.GetAll(); len(servers) == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the watch should fail over to the live registry")
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+}
+
+func TestGeeRegistryDiscovery_Namespace(t *testing.T) {
+	ts := httptest.NewServer(registry.New(time.Minute))
+	defer ts.Close()
+	registry.HeartbeatItem(ts.URL, &registry.ServerItem{Addr: "tcp@127.0.0.1:9996", Namespace: "prod", Services: []string{"Foo"}}, time.Minute)
+	registry.HeartbeatItem(ts.URL, &registry.ServerItem{Addr: "tcp@127.0.0.1:9997", Namespace: "prod", Services: []string{"Bar"}}, time.Minute)
+	registry.HeartbeatItem(ts.URL, &registry.ServerItem{Addr: "tcp@127.0.0.1:9998", Namespace: "staging", Services: []string{"Foo"}}, time.Minute)
+	registry.Heartbeat(ts.URL, "tcp@127.0.0.1:9999", time.Minute)
+
+	d := NewGeeRegistryDiscovery(ts.URL, time.Minute)
+	d.SetFilter(registry.ServerFilter{Namespace: "prod", Service: "Foo"})
+	if servers, _ := d.GetAll(); len(servers) != 1 || servers[0] != "tcp@127.0.0.1:9996" {
+		t.Fatalf("expect Foo in prod only, got %v", servers)
+	}
+	d.SetFilter(registry.ServerFilter{Namespace: registry.DefaultNamespace})
+	if servers, _ := d.GetAll(); len(servers) != 1 || servers[0] != "tcp@127.0.0.1:9999" {
+		t.Fatalf("expect servers without namespace in the default one, got %v", servers)
+	}
+}
+
+func TestProbeHealth(t *testing.T) {
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer func() { _ = l.Close() }()
+	server := service.NewServer()
+	go server.Accept(l)
+	// a wedged server: connections are queued by the kernel but never served
+	wedged, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer func() { _ = wedged.Close() }()
+
+	good := "tcp@" + l.Addr().String()
+	if err := ProbeHealth(good, time.Second); err != nil {
+		t.Fatal("expect a server without Health to pass the probe:", err)
+	}
+	if err := ProbeHealth("tcp@"+wedged.Addr().String(), time.Millisecond*100); err == nil {
+		t.Fatal("expect a wedged server to fail the probe")
+	}
+	_ = server.EnableHealth()
+	if err := ProbeHealth(good, time.Second); err != nil {
+		t.Fatal("expect a serving server to pass the probe:", err)
+	}
+	server.Health().Shutdown()
+	if err := ProbeHealth(good, time.Second); err == nil {
+		t.Fatal("a draining server should fail the probe")
+	}
+}